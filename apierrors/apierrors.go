@@ -0,0 +1,90 @@
+// Package apierrors defines the error envelope every API endpoint answers with:
+//
+//	{"error": "human readable message", "code": "machine_readable_code", "request_id": "..."}
+//
+// Some codes add fields of their own, for instance "fields" for validation errors.
+package apierrors
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Code is the machine readable reason of an error, the frontend switches on it
+type Code string
+
+const (
+	CodeInvalidRequest     Code = "invalid_request"
+	CodeValidationFailed   Code = "validation_failed"
+	CodeWeakPassword       Code = "weak_password"
+	CodeUnauthorized       Code = "unauthorized"
+	CodeInvalidCredentials Code = "invalid_credentials"
+	CodeInvalidToken       Code = "invalid_token"
+	CodeSessionExpired     Code = "session_expired"
+	CodeInvalidMFACode     Code = "invalid_mfa_code"
+	CodeForbidden          Code = "forbidden"
+	CodeAccountNotVerified Code = "account_not_verified"
+	CodeNotFound           Code = "not_found"
+	CodeConflict           Code = "conflict"
+	CodeEmailTaken         Code = "email_taken"
+	CodeUsernameTaken      Code = "username_taken"
+	CodeSlotConflict       Code = "slot_conflict"
+	CodeCancellationClosed Code = "cancellation_window_closed"
+	CodeTokenExpired       Code = "token_expired"
+	CodeRateLimited        Code = "rate_limited"
+	CodeInternal           Code = "internal_error"
+)
+
+var statuses = map[Code]int{
+	CodeInvalidRequest:     http.StatusBadRequest,
+	CodeValidationFailed:   http.StatusBadRequest,
+	CodeWeakPassword:       http.StatusBadRequest,
+	CodeUnauthorized:       http.StatusUnauthorized,
+	CodeInvalidCredentials: http.StatusUnauthorized,
+	CodeInvalidToken:       http.StatusUnauthorized,
+	CodeSessionExpired:     http.StatusUnauthorized,
+	CodeInvalidMFACode:     http.StatusUnauthorized,
+	CodeForbidden:          http.StatusForbidden,
+	CodeAccountNotVerified: http.StatusForbidden,
+	CodeNotFound:           http.StatusNotFound,
+	CodeConflict:           http.StatusConflict,
+	CodeEmailTaken:         http.StatusConflict,
+	CodeUsernameTaken:      http.StatusConflict,
+	CodeSlotConflict:       http.StatusConflict,
+	CodeCancellationClosed: http.StatusConflict,
+	CodeTokenExpired:       http.StatusGone,
+	CodeRateLimited:        http.StatusTooManyRequests,
+	CodeInternal:           http.StatusInternalServerError,
+}
+
+// Status returns the HTTP status a code is answered with
+func (code Code) Status() int {
+	if status, ok := statuses[code]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// Abort ends the request with the error envelope. Extra fields are merged into
+// the body, they cannot override the envelope's own fields.
+func Abort(c *gin.Context, code Code, message string, extra ...gin.H) {
+	body := gin.H{}
+	for _, fields := range extra {
+		for key, value := range fields {
+			body[key] = value
+		}
+	}
+	body["error"] = message
+	body["code"] = code
+	body["request_id"] = GetRequestID(c)
+	c.AbortWithStatusJSON(code.Status(), body)
+}
+
+// Internal logs err with the request ID and ends the request with a generic 500,
+// database and file system errors are never sent to the client
+func Internal(c *gin.Context, context string, err error) {
+	log.Printf("[%s] %s: %v", GetRequestID(c), context, err)
+	Abort(c, CodeInternal, "Internal server error")
+}
@@ -0,0 +1,35 @@
+package apierrors
+
+import (
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader carries the request ID in both directions
+const RequestIDHeader = "X-Request-ID"
+
+const requestIDKey = "request_id"
+
+// IDs coming from a proxy are kept when they look sane, anything else is replaced
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// RequestID gives every request an ID, echoed in the X-Request-ID response header
+// and in error responses so that a report from a user can be matched with the logs
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			id = uuid.New().String()
+		}
+		c.Set(requestIDKey, id)
+		c.Header(RequestIDHeader, id)
+		c.Next()
+	}
+}
+
+// GetRequestID returns the ID set by RequestID
+func GetRequestID(c *gin.Context) string {
+	return c.GetString(requestIDKey)
+}
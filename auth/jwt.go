@@ -1,29 +1,124 @@
 package auth
 
 import (
+	"errors"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
 )
 
 type User struct {
-	ID string `json:"id"`
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Verified bool   `json:"verified"`
 }
 
+// Claims are the claims of an access token, the subject is the patient_id or doctor_id
+type Claims struct {
+	UserID    string `json:"userId"` // same as the subject, kept for clients that still read userId
+	UserType  string `json:"userType"`
+	Verified  bool   `json:"verified"`
+	SessionID string `json:"sessionId,omitempty"`
+	Purpose   string `json:"purpose,omitempty"` // empty for access tokens
+	jwt.StandardClaims
+}
+
+// AccessTokenTTL is how long an access token stays valid, clients renew it with their refresh token
+const AccessTokenTTL = 15 * time.Minute
+
+// MFATokenTTL is how long a user has to enter their second factor after the password step
+const MFATokenTTL = 5 * time.Minute
+
+// PurposeMFA marks a token that only proves the password step of a login and
+// can be exchanged for an access token with a TOTP or recovery code
+const PurposeMFA = "mfa"
 
-func GenerateToken(user User, userType string) (string, error) {
-	expirationTime := time.Now().Add(15 * time.Minute)
+const tokenIssuer = "tbibi"
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"userId":   user.ID,
-		"userType": userType,
-		"exp":      expirationTime.Unix(),
-	  })
+func GenerateToken(user User, sessionID string) (string, error) {
+	now := time.Now()
 
-	tokenString, err := token.SignedString([]byte("your-secret"))
+	claims := Claims{
+		UserID:    user.ID,
+		UserType:  user.Type,
+		Verified:  user.Verified,
+		SessionID: sessionID,
+		StandardClaims: jwt.StandardClaims{
+			Subject:   user.ID,
+			Id:        uuid.NewString(),
+			Issuer:    tokenIssuer,
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: now.Add(AccessTokenTTL).Unix(),
+		},
+	}
+
+	tokenString, err := signToken(claims)
 	if err != nil {
 		return "", err
 	}
 
 	return tokenString, nil
-}
\ No newline at end of file
+}
+
+// GenerateMFAToken issues the short lived token returned by the password step of a login when 2FA is on
+func GenerateMFAToken(user User) (string, error) {
+	now := time.Now()
+
+	claims := Claims{
+		UserID:   user.ID,
+		UserType: user.Type,
+		Verified: user.Verified,
+		Purpose:  PurposeMFA,
+		StandardClaims: jwt.StandardClaims{
+			Subject:   user.ID,
+			Id:        uuid.NewString(),
+			Issuer:    tokenIssuer,
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: now.Add(MFATokenTTL).Unix(),
+		},
+	}
+
+	return signToken(claims)
+}
+
+// ParseToken verifies the signature and expiry of an access token and returns its claims
+func ParseToken(tokenString string) (*Claims, error) {
+	claims, err := parseClaims(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != "" {
+		return nil, errors.New("not an access token")
+	}
+	return claims, nil
+}
+
+// ParseMFAToken verifies a token issued by GenerateMFAToken and returns its claims
+func ParseMFAToken(tokenString string) (*Claims, error) {
+	claims, err := parseClaims(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != PurposeMFA {
+		return nil, errors.New("not an mfa token")
+	}
+	return claims, nil
+}
+
+func parseClaims(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, verificationKey)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+	if claims.Subject == "" || claims.UserType == "" {
+		return nil, errors.New("token is missing the user identity")
+	}
+	return claims, nil
+}
@@ -0,0 +1,264 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+)
+
+// defaultKeyID is used for JWT_SECRET and for tokens issued before keys had an ID
+const defaultKeyID = "default"
+
+type signingKey struct {
+	id        string
+	method    jwt.SigningMethod
+	signKey   interface{} // nil for retired keys that are only kept to verify tokens
+	verifyKey interface{}
+}
+
+type keySet struct {
+	activeID string
+	keys     map[string]*signingKey
+}
+
+var keys *keySet
+
+// LoadKeysFromEnv loads the JWT keys from the environment:
+//
+//	JWT_SECRET          a single HS256 secret, registered under the "default" key ID
+//	JWT_SECRETS         HS256 secrets as "kid:secret,kid:secret"
+//	JWT_PRIVATE_KEYS    RS256 or EdDSA PEM private keys as "kid:/path/key.pem,..."
+//	JWT_PUBLIC_KEYS     retired RS256 or EdDSA PEM public keys that are still accepted
+//	JWT_ACTIVE_KEY_ID   the key used to sign new tokens, defaults to the first configured key
+//
+// Rotating a key means adding the new one, making it active and removing the
+// old one once every token it signed has expired.
+func LoadKeysFromEnv() error {
+	set := &keySet{keys: map[string]*signingKey{}}
+	var order []string
+
+	add := func(key *signingKey) error {
+		if _, exists := set.keys[key.id]; exists {
+			return fmt.Errorf("duplicate JWT key id %q", key.id)
+		}
+		set.keys[key.id] = key
+		order = append(order, key.id)
+		return nil
+	}
+
+	if secret := os.Getenv("JWT_SECRET"); secret != "" {
+		if err := add(&signingKey{id: defaultKeyID, method: jwt.SigningMethodHS256, signKey: []byte(secret), verifyKey: []byte(secret)}); err != nil {
+			return err
+		}
+	}
+
+	for _, entry := range splitKeyList(os.Getenv("JWT_SECRETS")) {
+		if err := add(&signingKey{id: entry[0], method: jwt.SigningMethodHS256, signKey: []byte(entry[1]), verifyKey: []byte(entry[1])}); err != nil {
+			return err
+		}
+	}
+
+	for _, entry := range splitKeyList(os.Getenv("JWT_PRIVATE_KEYS")) {
+		key, err := loadPrivateKey(entry[0], entry[1])
+		if err != nil {
+			return err
+		}
+		if err := add(key); err != nil {
+			return err
+		}
+	}
+
+	for _, entry := range splitKeyList(os.Getenv("JWT_PUBLIC_KEYS")) {
+		key, err := loadPublicKey(entry[0], entry[1])
+		if err != nil {
+			return err
+		}
+		if err := add(key); err != nil {
+			return err
+		}
+	}
+
+	if len(order) == 0 {
+		return errors.New("no JWT signing key configured, set JWT_SECRET, JWT_SECRETS or JWT_PRIVATE_KEYS")
+	}
+
+	set.activeID = os.Getenv("JWT_ACTIVE_KEY_ID")
+	if set.activeID == "" {
+		set.activeID = order[0]
+	}
+	active, ok := set.keys[set.activeID]
+	if !ok {
+		return fmt.Errorf("JWT_ACTIVE_KEY_ID %q does not match any configured key", set.activeID)
+	}
+	if active.signKey == nil {
+		return fmt.Errorf("JWT key %q is a public key and cannot sign tokens", set.activeID)
+	}
+
+	keys = set
+	return nil
+}
+
+// splitKeyList parses "kid:value,kid:value" into pairs
+func splitKeyList(value string) [][2]string {
+	var entries [][2]string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		entries = append(entries, [2]string{strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])})
+	}
+	return entries
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file %s: %v", path, err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", path)
+	}
+	return block, nil
+}
+
+func loadPrivateKey(id, path string) (*signingKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var parsed interface{}
+	if block.Type == "RSA PRIVATE KEY" {
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	} else {
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key %s: %v", path, err)
+	}
+
+	switch key := parsed.(type) {
+	case *rsa.PrivateKey:
+		return &signingKey{id: id, method: jwt.SigningMethodRS256, signKey: key, verifyKey: &key.PublicKey}, nil
+	case ed25519.PrivateKey:
+		return &signingKey{id: id, method: SigningMethodEdDSA, signKey: key, verifyKey: key.Public()}, nil
+	default:
+		return nil, fmt.Errorf("unsupported private key type in %s", path)
+	}
+}
+
+func loadPublicKey(id, path string) (*signingKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var parsed interface{}
+	if block.Type == "RSA PUBLIC KEY" {
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	} else {
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key %s: %v", path, err)
+	}
+
+	switch key := parsed.(type) {
+	case *rsa.PublicKey:
+		return &signingKey{id: id, method: jwt.SigningMethodRS256, verifyKey: key}, nil
+	case ed25519.PublicKey:
+		return &signingKey{id: id, method: SigningMethodEdDSA, verifyKey: key}, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type in %s", path)
+	}
+}
+
+// signToken signs the claims with the active key and sets the kid header
+func signToken(claims jwt.Claims) (string, error) {
+	if keys == nil {
+		return "", errors.New("JWT keys are not loaded")
+	}
+	key := keys.keys[keys.activeID]
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.id
+	return token.SignedString(key.signKey)
+}
+
+// verificationKey is the jwt.Keyfunc that picks the key a token was signed with from its kid header
+func verificationKey(token *jwt.Token) (interface{}, error) {
+	if keys == nil {
+		return nil, errors.New("JWT keys are not loaded")
+	}
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		kid = defaultKeyID
+	}
+	key, ok := keys.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.verifyKey, nil
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKSHandler publishes the asymmetric public keys so other services can verify tokens, HMAC secrets are never exposed
+func JWKSHandler(c *gin.Context) {
+	jwks := []jsonWebKey{}
+	if keys != nil {
+		for _, key := range keys.keys {
+			switch pub := key.verifyKey.(type) {
+			case *rsa.PublicKey:
+				jwks = append(jwks, jsonWebKey{
+					Kty: "RSA",
+					Kid: key.id,
+					Use: "sig",
+					Alg: key.method.Alg(),
+					N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+					E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+				})
+			case ed25519.PublicKey:
+				jwks = append(jwks, jsonWebKey{
+					Kty: "OKP",
+					Kid: key.id,
+					Use: "sig",
+					Alg: key.method.Alg(),
+					Crv: "Ed25519",
+					X:   base64.RawURLEncoding.EncodeToString(pub),
+				})
+			}
+		}
+	}
+
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{"keys": jwks})
+}
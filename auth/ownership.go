@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Querier is satisfied by both *pgxpool.Pool and *pgx.Conn
+type Querier interface {
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// IsFolderFileOwner reports whether the folder_file_info row belongs to the user
+func IsFolderFileOwner(ctx context.Context, db Querier, itemID string, userID string) (bool, error) {
+	var owned bool
+	err := db.QueryRow(ctx,
+		"SELECT EXISTS (SELECT 1 FROM folder_file_info WHERE id::text = $1 AND user_id::text = $2)",
+		itemID, userID).Scan(&owned)
+	return owned, err
+}
+
+// CanAccessFolderFile reports whether the user owns the folder_file_info row, or it or one of its parent folders was shared with them
+func CanAccessFolderFile(ctx context.Context, db Querier, itemID string, userID string) (bool, error) {
+	var allowed bool
+	err := db.QueryRow(ctx, `
+		WITH RECURSIVE ancestors AS (
+			SELECT id, parent_id, user_id FROM folder_file_info WHERE id::text = $1
+			UNION ALL
+			SELECT f.id, f.parent_id, f.user_id FROM folder_file_info f
+			INNER JOIN ancestors a ON a.parent_id = f.id
+		)
+		SELECT EXISTS (SELECT 1 FROM ancestors WHERE id::text = $1 AND user_id::text = $2)
+			OR EXISTS (SELECT 1 FROM shared_items s JOIN ancestors a ON s.item_id = a.id WHERE s.shared_with_id = $2)`,
+		itemID, userID).Scan(&allowed)
+	return allowed, err
+}
+
+// IsChatParticipant reports whether the user is an active participant of the chat
+func IsChatParticipant(ctx context.Context, db Querier, chatID string, userID string) (bool, error) {
+	var participant bool
+	err := db.QueryRow(ctx,
+		"SELECT EXISTS (SELECT 1 FROM participants WHERE chat_id::text = $1 AND user_id::text = $2 AND deleted_at IS NULL)",
+		chatID, userID).Scan(&participant)
+	return participant, err
+}
+
+// IsAvailabilityOwner reports whether the availability slot belongs to the doctor
+func IsAvailabilityOwner(ctx context.Context, db Querier, availabilityID string, doctorID string) (bool, error) {
+	var owned bool
+	err := db.QueryRow(ctx,
+		"SELECT EXISTS (SELECT 1 FROM availabilities WHERE availability_id::text = $1 AND doctor_id::text = $2)",
+		availabilityID, doctorID).Scan(&owned)
+	return owned, err
+}
+
+// IsAppointmentParticipant reports whether the user is the doctor or the patient of the appointment
+func IsAppointmentParticipant(ctx context.Context, db Querier, appointmentID string, userID string) (bool, error) {
+	var participant bool
+	err := db.QueryRow(ctx,
+		"SELECT EXISTS (SELECT 1 FROM appointments WHERE appointment_id::text = $1 AND (doctor_id::text = $2 OR patient_id::text = $2))",
+		appointmentID, userID).Scan(&participant)
+	return participant, err
+}
+
+// IsDoctorOfPatient reports whether the patient has an appointment with the doctor or shared items with them
+func IsDoctorOfPatient(ctx context.Context, db Querier, patientID string, doctorID string) (bool, error) {
+	var related bool
+	err := db.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM appointments WHERE patient_id::text = $1 AND doctor_id::text = $2)
+			OR EXISTS (SELECT 1 FROM shared_items WHERE shared_by_id = $1 AND shared_with_id = $2)`,
+		patientID, doctorID).Scan(&related)
+	return related, err
+}
+
+// OwnsFolderFile checks that the caller owns the folder or file in the given path parameter
+func OwnsFolderFile(pool *pgxpool.Pool, param string) OwnershipCheck {
+	return func(c *gin.Context, userID string, userType string) (bool, error) {
+		return IsFolderFileOwner(c.Request.Context(), pool, c.Param(param), userID)
+	}
+}
+
+// CanReadFolderFile checks that the caller owns the folder or file in the given path parameter or that it was shared with them
+func CanReadFolderFile(pool *pgxpool.Pool, param string) OwnershipCheck {
+	return func(c *gin.Context, userID string, userType string) (bool, error) {
+		return CanAccessFolderFile(c.Request.Context(), pool, c.Param(param), userID)
+	}
+}
+
+// ParticipantOfChat checks that the caller is a participant of the chat in the given path parameter
+func ParticipantOfChat(pool *pgxpool.Pool, param string) OwnershipCheck {
+	return func(c *gin.Context, userID string, userType string) (bool, error) {
+		return IsChatParticipant(c.Request.Context(), pool, c.Param(param), userID)
+	}
+}
+
+// PatientOrTheirDoctor allows the patient in the given path parameter, admins,
+// and doctors the patient has an appointment with or shared items with
+func PatientOrTheirDoctor(pool *pgxpool.Pool, param string) OwnershipCheck {
+	return func(c *gin.Context, userID string, userType string) (bool, error) {
+		patientID := c.Param(param)
+		switch {
+		case patientID == userID || userType == RoleAdmin:
+			return true, nil
+		case userType == RoleDoctor:
+			return IsDoctorOfPatient(c.Request.Context(), pool, patientID, userID)
+		}
+		return false, nil
+	}
+}
+
+// OwnsAvailability checks that the caller is the doctor of the availability slot in the given path parameter
+func OwnsAvailability(pool *pgxpool.Pool, param string) OwnershipCheck {
+	return func(c *gin.Context, userID string, userType string) (bool, error) {
+		return IsAvailabilityOwner(c.Request.Context(), pool, c.Param(param), userID)
+	}
+}
+
+// ParticipantOfAppointment checks that the caller is the doctor or the patient of the appointment in the given path parameter
+func ParticipantOfAppointment(pool *pgxpool.Pool, param string) OwnershipCheck {
+	return func(c *gin.Context, userID string, userType string) (bool, error) {
+		return IsAppointmentParticipant(c.Request.Context(), pool, c.Param(param), userID)
+	}
+}
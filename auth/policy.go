@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"tbibi_back_end_go/apierrors"
+
+	"github.com/gin-gonic/gin"
+)
+
+// User types a token can carry
+const (
+	RolePatient = "patient"
+	RoleDoctor  = "doctor"
+	RoleAdmin   = "admin"
+)
+
+// OwnershipCheck reports whether the authenticated user may access the resource the request points to
+type OwnershipCheck func(c *gin.Context, userID string, userType string) (bool, error)
+
+// AbortUnauthorized ends the request with the standard 401 response
+func AbortUnauthorized(c *gin.Context) {
+	apierrors.Abort(c, apierrors.CodeUnauthorized, "Authentication required")
+}
+
+// AbortForbidden ends the request with the standard 403 response
+func AbortForbidden(c *gin.Context) {
+	apierrors.Abort(c, apierrors.CodeForbidden, "You do not have access to this resource")
+}
+
+// HasRole reports whether the authenticated user has one of the given roles
+func HasRole(c *gin.Context, roles ...string) bool {
+	userType := GetUserType(c)
+	for _, role := range roles {
+		if userType == role {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireRoles only lets users with one of the given roles through. It must be
+// attached after AuthMiddleware.
+func RequireRoles(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if GetUserID(c) == "" {
+			AbortUnauthorized(c)
+			return
+		}
+		if !HasRole(c, roles...) {
+			AbortForbidden(c)
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireOwnership only lets the request through when every check passes. It
+// must be attached after AuthMiddleware.
+func RequireOwnership(checks ...OwnershipCheck) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := GetUserID(c)
+		if userID == "" {
+			AbortUnauthorized(c)
+			return
+		}
+		for _, check := range checks {
+			allowed, err := check(c, userID, GetUserType(c))
+			if err != nil {
+				apierrors.Internal(c, "Authorization check error", err)
+				return
+			}
+			if !allowed {
+				AbortForbidden(c)
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// SelfOrRoles allows the user whose ID is in the given path parameter, and users with one of the roles
+func SelfOrRoles(param string, roles ...string) OwnershipCheck {
+	return func(c *gin.Context, userID string, userType string) (bool, error) {
+		return c.Param(param) == userID || HasRole(c, roles...), nil
+	}
+}
@@ -0,0 +1,27 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// RefreshTokenTTL is how long a session can stay idle before the user has to log in again
+const RefreshTokenTTL = 30 * 24 * time.Hour
+
+// GenerateRefreshToken returns a random opaque refresh token and the hash that is stored server side
+func GenerateRefreshToken() (string, string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", "", err
+	}
+	token := hex.EncodeToString(bytes)
+	return token, HashRefreshToken(token), nil
+}
+
+// HashRefreshToken hashes a refresh token for storage and lookup, the raw token is never persisted
+func HashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
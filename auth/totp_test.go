@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+// Secret of the SHA1 test vectors of RFC 6238, appendix B
+var rfc6238Secret = totpEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestValidateTOTPVectors(t *testing.T) {
+	// the RFC lists 8 digit codes, the 6 digit ones are their last 6 digits
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, test := range tests {
+		step, ok := ValidateTOTP(rfc6238Secret, test.code, time.Unix(test.unix, 0))
+		if !ok {
+			t.Errorf("code %s at %d: refused", test.code, test.unix)
+			continue
+		}
+		if step != test.unix/totpPeriod {
+			t.Errorf("code %s at %d: matched step %d, want %d", test.code, test.unix, step, test.unix/totpPeriod)
+		}
+	}
+}
+
+func TestValidateTOTPWindow(t *testing.T) {
+	key, err := totpEncoding.DecodeString(rfc6238Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1111111111, 0)
+	current := now.Unix() / totpPeriod
+
+	tests := []struct {
+		name   string
+		offset int64
+		ok     bool
+	}{
+		{"current step", 0, true},
+		{"previous step", -1, true},
+		{"next step", 1, true},
+		{"two steps back", -2, false},
+		{"two steps ahead", 2, false},
+	}
+
+	for _, test := range tests {
+		step, ok := ValidateTOTP(rfc6238Secret, totpCode(key, current+test.offset), now)
+		if ok != test.ok {
+			t.Errorf("%s: accepted = %v, want %v", test.name, ok, test.ok)
+		}
+		if ok && step != current+test.offset {
+			t.Errorf("%s: matched step %d, want %d", test.name, step, current+test.offset)
+		}
+	}
+}
+
+func TestValidateTOTPMalformed(t *testing.T) {
+	now := time.Unix(59, 0)
+	for _, test := range []struct {
+		name, secret, code string
+	}{
+		{"short code", rfc6238Secret, "28708"},
+		{"long code", rfc6238Secret, "2870820"},
+		{"wrong code", rfc6238Secret, "287083"},
+		{"invalid secret", "not base32!", "287082"},
+	} {
+		if _, ok := ValidateTOTP(test.secret, test.code, now); ok {
+			t.Errorf("%s: accepted", test.name)
+		}
+	}
+	if _, ok := ValidateTOTP(rfc6238Secret, " 287082 ", now); !ok {
+		t.Error("code with surrounding spaces refused")
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(RecoveryCodeCount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != RecoveryCodeCount {
+		t.Fatalf("got %d codes, want %d", len(codes), RecoveryCodeCount)
+	}
+	hashes := map[string]bool{}
+	for _, code := range codes {
+		if len(code) != 19 || code[4] != '-' || code[9] != '-' || code[14] != '-' {
+			t.Errorf("code %q is not formatted as XXXX-XXXX-XXXX-XXXX", code)
+		}
+		hashes[HashRecoveryCode(code)] = true
+	}
+	if len(hashes) != RecoveryCodeCount {
+		t.Error("recovery codes are not unique")
+	}
+
+	// users may type the code without dashes, in lower case or with spaces
+	if HashRecoveryCode("abcd efgh-ijkl-mnop") != HashRecoveryCode("ABCD-EFGH-IJKL-MNOP") {
+		t.Error("hash depends on case, spaces or dashes")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/joho/godotenv"
@@ -34,6 +35,19 @@ func InitDatabase() (*pgxpool.Pool, error) {
 	// Create tables
 	sqlQueries := []string{
 		`CREATE EXTENSION IF NOT EXISTS "uuid-ossp"`,
+
+		// One account per email for every user type, the profile tables reference it
+		`CREATE TABLE IF NOT EXISTS users (
+			user_id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+			email VARCHAR(255) NOT NULL,
+			hashed_password TEXT NOT NULL,
+			user_type VARCHAR(20) NOT NULL,
+			is_verified BOOLEAN NOT NULL DEFAULT FALSE,
+			created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+		)`,
+
+		`CREATE UNIQUE INDEX IF NOT EXISTS users_email_idx ON users (LOWER(email))`,
 		
 		
 
@@ -66,6 +80,21 @@ func InitDatabase() (*pgxpool.Pool, error) {
 			location VARCHAR(50) NOT NULL
 		)`,
 
+		`ALTER TABLE doctor_info ADD COLUMN IF NOT EXISTS license_status VARCHAR(20) NOT NULL DEFAULT 'pending'`,
+		`ALTER TABLE doctor_info ADD COLUMN IF NOT EXISTS license_reviewed_at TIMESTAMP`,
+		`ALTER TABLE doctor_info ADD COLUMN IF NOT EXISTS license_reviewed_by uuid`,
+		`ALTER TABLE doctor_info ADD COLUMN IF NOT EXISTS license_rejection_reason TEXT`,
+
+		`CREATE TABLE IF NOT EXISTS admin_info (
+			admin_id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+			first_name VARCHAR(50) NOT NULL,
+			last_name VARCHAR(50) NOT NULL,
+			email VARCHAR(50) NOT NULL UNIQUE,
+			hashed_password TEXT NOT NULL,
+			create_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			update_at TIMESTAMP NOT NULL DEFAULT NOW()
+		)`,
+
 		`CREATE TABLE IF NOT EXISTS patient_info (
 			patient_id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
 			username VARCHAR(50) NOT NULL,
@@ -85,7 +114,7 @@ func InitDatabase() (*pgxpool.Pool, error) {
 			city_name VARCHAR(50) NOT NULL,
 			state_name VARCHAR(50) NOT NULL,
 			zip_code VARCHAR(50) NOT NULL,
-			country_address VARCHAR(50) NOT NULL,
+			country_name VARCHAR(50) NOT NULL,
 			birth_date DATE NOT NULL,
 			location VARCHAR(50) NOT NULL
 		)`,
@@ -93,16 +122,16 @@ func InitDatabase() (*pgxpool.Pool, error) {
 
 		`CREATE TABLE IF NOT EXISTS availabilities (
 			availability_id SERIAL PRIMARY KEY,
-			availability_start TIMESTAMP NOT NULL,
-			availability_end TIMESTAMP NOT NULL,
+			availability_start TIMESTAMPTZ NOT NULL,
+			availability_end TIMESTAMPTZ NOT NULL,
 			doctor_id uuid REFERENCES doctor_info(doctor_id)
 		)`,
 
 
 		`CREATE TABLE IF NOT EXISTS appointments (
 			appointment_id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
-			appointment_start TIMESTAMP NOT NULL,
-			appointment_end TIMESTAMP NOT NULL,
+			appointment_start TIMESTAMPTZ NOT NULL,
+			appointment_end TIMESTAMPTZ NOT NULL,
 			title VARCHAR(50) NOT NULL,
 			doctor_id uuid REFERENCES doctor_info(doctor_id),
 			patient_id uuid REFERENCES patient_info(patient_id)
@@ -158,6 +187,213 @@ func InitDatabase() (*pgxpool.Pool, error) {
 			deleted_at TIMESTAMP
 		)`,
 
+		// Move existing accounts into users, keeping the profile IDs as user IDs.
+		// When an email exists in several profile tables the first one wins and
+		// the others are left without a user_id, see logUnmigratedAccounts.
+		`ALTER TABLE admin_info ADD COLUMN IF NOT EXISTS user_id uuid REFERENCES users(user_id)`,
+		`ALTER TABLE doctor_info ADD COLUMN IF NOT EXISTS user_id uuid REFERENCES users(user_id)`,
+		`ALTER TABLE patient_info ADD COLUMN IF NOT EXISTS user_id uuid REFERENCES users(user_id)`,
+
+		`INSERT INTO users (user_id, email, hashed_password, user_type, is_verified, created_at, updated_at)
+			SELECT admin_id, email, hashed_password, 'admin', TRUE, create_at, update_at FROM admin_info WHERE user_id IS NULL
+			ON CONFLICT DO NOTHING`,
+		`INSERT INTO users (user_id, email, hashed_password, user_type, is_verified, created_at, updated_at)
+			SELECT doctor_id, email, hashed_password, 'doctor', is_verified, create_at, update_at FROM doctor_info WHERE user_id IS NULL
+			ON CONFLICT DO NOTHING`,
+		`INSERT INTO users (user_id, email, hashed_password, user_type, is_verified, created_at, updated_at)
+			SELECT patient_id, email, hashed_password, 'patient', is_verified, create_at, update_at FROM patient_info WHERE user_id IS NULL
+			ON CONFLICT DO NOTHING`,
+
+		`UPDATE admin_info a SET user_id = a.admin_id FROM users u WHERE a.user_id IS NULL AND u.user_id = a.admin_id`,
+		`UPDATE doctor_info d SET user_id = d.doctor_id FROM users u WHERE d.user_id IS NULL AND u.user_id = d.doctor_id`,
+		`UPDATE patient_info p SET user_id = p.patient_id FROM users u WHERE p.user_id IS NULL AND u.user_id = p.patient_id`,
+
+		// Credentials and verification now live in users
+		`ALTER TABLE admin_info ALTER COLUMN hashed_password DROP NOT NULL`,
+		`ALTER TABLE doctor_info ALTER COLUMN hashed_password DROP NOT NULL`,
+		`ALTER TABLE doctor_info ALTER COLUMN salt DROP NOT NULL`,
+		`ALTER TABLE patient_info ALTER COLUMN hashed_password DROP NOT NULL`,
+		`ALTER TABLE patient_info ALTER COLUMN salt DROP NOT NULL`,
+
+		`CREATE TABLE IF NOT EXISTS refresh_tokens (
+			session_id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+			user_id uuid NOT NULL,
+			user_type VARCHAR(50) NOT NULL,
+			token_hash VARCHAR(64) NOT NULL UNIQUE,
+			previous_token_hash VARCHAR(64),
+			user_agent TEXT NOT NULL DEFAULT '',
+			ip_address VARCHAR(64) NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			last_used_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			expires_at TIMESTAMPTZ NOT NULL,
+			revoked_at TIMESTAMPTZ
+		)`,
+		// expires_at is set from Go and compared with NOW()
+		timestamptzColumns("refresh_tokens", "created_at", "last_used_at", "expires_at", "revoked_at"),
+
+		`CREATE INDEX IF NOT EXISTS refresh_tokens_user_id_idx ON refresh_tokens (user_id)`,
+		`CREATE INDEX IF NOT EXISTS refresh_tokens_previous_hash_idx ON refresh_tokens (previous_token_hash)`,
+
+		`CREATE TABLE IF NOT EXISTS login_attempts (
+			id BIGSERIAL PRIMARY KEY,
+			email VARCHAR(255) NOT NULL,
+			ip_address VARCHAR(64) NOT NULL,
+			user_id uuid,
+			succeeded BOOLEAN NOT NULL,
+			failure_reason VARCHAR(50),
+			user_agent TEXT NOT NULL DEFAULT '',
+			attempted_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`,
+		// compared with the time of the server process, see timestamptzColumns
+		timestamptzColumns("login_attempts", "attempted_at"),
+
+		`CREATE INDEX IF NOT EXISTS login_attempts_email_idx ON login_attempts (LOWER(email), attempted_at)`,
+		`CREATE INDEX IF NOT EXISTS login_attempts_ip_idx ON login_attempts (ip_address, attempted_at)`,
+
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret TEXT`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled BOOLEAN NOT NULL DEFAULT FALSE`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_required BOOLEAN NOT NULL DEFAULT FALSE`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_step BIGINT`,
+
+		`CREATE TABLE IF NOT EXISTS recovery_codes (
+			id BIGSERIAL PRIMARY KEY,
+			user_id uuid NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+			code_hash VARCHAR(64) NOT NULL,
+			used_at TIMESTAMP,
+			created_at TIMESTAMP NOT NULL DEFAULT NOW()
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS recovery_codes_user_idx ON recovery_codes (user_id)`,
+
+		// Account activation and password reset tokens
+		`CREATE TABLE IF NOT EXISTS verification_tokens (
+			token VARCHAR(64) PRIMARY KEY,
+			email VARCHAR(255) NOT NULL,
+			type VARCHAR(50) NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			expires_at TIMESTAMPTZ NOT NULL DEFAULT NOW() + INTERVAL '24 hours'
+		)`,
+
+		// Databases where the table was created by hand lack the expiry columns
+		`ALTER TABLE verification_tokens ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()`,
+		`ALTER TABLE verification_tokens ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ NOT NULL DEFAULT NOW() + INTERVAL '24 hours'`,
+		// expires_at is set from Go and checked in Go, the resend throttle compares created_at with a Go time
+		timestamptzColumns("verification_tokens", "created_at", "expires_at"),
+
+		`CREATE INDEX IF NOT EXISTS verification_tokens_email_idx ON verification_tokens (LOWER(email), type)`,
+
+		// Language of the emails sent to the user
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS preferred_language VARCHAR(5) NOT NULL DEFAULT 'en'`,
+
+		// Emails waiting to be delivered by the outbox worker
+		`CREATE TABLE IF NOT EXISTS email_outbox (
+			id BIGSERIAL PRIMARY KEY,
+			recipient VARCHAR(255) NOT NULL,
+			subject TEXT NOT NULL,
+			text_body TEXT NOT NULL,
+			html_body TEXT NOT NULL DEFAULT '',
+			status VARCHAR(20) NOT NULL DEFAULT 'pending',
+			attempts INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT,
+			next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			sent_at TIMESTAMPTZ,
+			failed_at TIMESTAMPTZ
+		)`,
+		timestamptzColumns("email_outbox", "next_attempt_at", "created_at", "sent_at", "failed_at"),
+
+		`CREATE INDEX IF NOT EXISTS email_outbox_pending_idx ON email_outbox (next_attempt_at) WHERE status = 'pending'`,
+
+		// Password reset tokens are only stored hashed
+		`CREATE TABLE IF NOT EXISTS password_reset_tokens (
+			token_hash VARCHAR(64) PRIMARY KEY,
+			user_id uuid NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+			requested_ip VARCHAR(64) NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			expires_at TIMESTAMPTZ NOT NULL,
+			used_at TIMESTAMPTZ
+		)`,
+		// expires_at is set from Go, created_at by NOW() and both are compared with Go times
+		timestamptzColumns("password_reset_tokens", "created_at", "expires_at", "used_at"),
+
+		`CREATE INDEX IF NOT EXISTS password_reset_tokens_user_idx ON password_reset_tokens (user_id)`,
+
+		// Reset tokens used to be kept in clear in verification_tokens
+		`DELETE FROM verification_tokens WHERE type = 'Password Reset'`,
+
+		// Columns built from several request fields, they overflowed VARCHAR(50) even with valid input
+		`ALTER TABLE patient_info ALTER COLUMN location TYPE VARCHAR(300)`,
+		`ALTER TABLE doctor_info ALTER COLUMN location TYPE VARCHAR(300)`,
+		`ALTER TABLE folder_file_info ALTER COLUMN path TYPE TEXT`,
+
+		// patient_info.country_address was never written, registration and profile updates use country_name
+		`DO $$ BEGIN
+			IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'patient_info' AND column_name = 'country_address') THEN
+				ALTER TABLE patient_info RENAME COLUMN country_address TO country_name;
+			END IF;
+		END $$`,
+
+		// Recurring weekly schedules, the generator turns them into availabilities.
+		// weekdays uses 0 for Sunday, start_time and end_time are wall clock times in time_zone.
+		`CREATE TABLE IF NOT EXISTS schedule_templates (
+			id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+			doctor_id uuid NOT NULL REFERENCES doctor_info(doctor_id) ON DELETE CASCADE,
+			weekdays INTEGER[] NOT NULL,
+			start_time TIME NOT NULL,
+			end_time TIME NOT NULL,
+			slot_minutes INTEGER NOT NULL,
+			break_minutes INTEGER NOT NULL DEFAULT 0,
+			time_zone VARCHAR(64) NOT NULL,
+			effective_from DATE NOT NULL,
+			effective_until DATE,
+			created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_schedule_templates_doctor ON schedule_templates(doctor_id)`,
+
+		// Days off, no slots are generated on them
+		`CREATE TABLE IF NOT EXISTS schedule_exceptions (
+			id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+			doctor_id uuid NOT NULL REFERENCES doctor_info(doctor_id) ON DELETE CASCADE,
+			exception_date DATE NOT NULL,
+			reason VARCHAR(100) NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			UNIQUE (doctor_id, exception_date)
+		)`,
+
+		// Generated slots remember their template, manually created ones have none
+		`ALTER TABLE availabilities ADD COLUMN IF NOT EXISTS schedule_template_id uuid REFERENCES schedule_templates(id) ON DELETE SET NULL`,
+
+		// Template slots the doctor deleted or moved by hand, the generator leaves them out
+		`CREATE TABLE IF NOT EXISTS schedule_skips (
+			doctor_id uuid NOT NULL REFERENCES doctor_info(doctor_id) ON DELETE CASCADE,
+			template_id uuid NOT NULL REFERENCES schedule_templates(id) ON DELETE CASCADE,
+			slot_start TIMESTAMPTZ NOT NULL,
+			PRIMARY KEY (template_id, slot_start)
+		)`,
+
+		// Appointments are kept when cancelled, status is one of booked, cancelled, completed and no_show
+		`ALTER TABLE appointments ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'booked'`,
+		`ALTER TABLE appointments ADD COLUMN IF NOT EXISTS cancelled_at TIMESTAMPTZ`,
+		`ALTER TABLE appointments ADD COLUMN IF NOT EXISTS cancelled_by uuid`,
+		`ALTER TABLE appointments ADD COLUMN IF NOT EXISTS cancellation_reason VARCHAR(300)`,
+		`CREATE INDEX IF NOT EXISTS idx_appointments_doctor_start ON appointments(doctor_id, appointment_start)`,
+
+		// Slots and appointments are instants, the TIMESTAMP columns held UTC wall clock times
+		`DO $$ DECLARE col RECORD; BEGIN
+			FOR col IN SELECT table_name, column_name FROM information_schema.columns
+				WHERE table_schema = current_schema() AND data_type = 'timestamp without time zone' AND (table_name::text, column_name::text) IN (
+					('availabilities', 'availability_start'), ('availabilities', 'availability_end'),
+					('appointments', 'appointment_start'), ('appointments', 'appointment_end'), ('appointments', 'cancelled_at'))
+			LOOP
+				EXECUTE format('ALTER TABLE %I ALTER COLUMN %I TYPE TIMESTAMPTZ USING %I AT TIME ZONE ''UTC''', col.table_name, col.column_name, col.column_name);
+			END LOOP;
+		END $$`,
+
+		// Practice time zone, the days of a doctor's calendar start at midnight there
+		`ALTER TABLE doctor_info ADD COLUMN IF NOT EXISTS time_zone VARCHAR(64) NOT NULL DEFAULT 'Europe/Berlin'`,
+
 
 	}
 
@@ -168,6 +404,41 @@ func InitDatabase() (*pgxpool.Pool, error) {
 		}
 	}
 
+	logUnmigratedAccounts(conn)
+
 	return conn, nil
 }
 
+// timestamptzColumns converts TIMESTAMP columns of a table that are compared
+// with times from Go to TIMESTAMPTZ. Their values are read as wall clock times
+// of the session's time zone, which is what NOW() wrote. Converted columns are
+// left alone, so it runs at every start.
+func timestamptzColumns(table string, columns ...string) string {
+	names := "'" + strings.Join(columns, "', '") + "'"
+	return `DO $$ DECLARE col RECORD; BEGIN
+			FOR col IN SELECT column_name FROM information_schema.columns
+				WHERE table_schema = current_schema() AND table_name = '` + table + `'
+				AND data_type = 'timestamp without time zone' AND column_name IN (` + names + `)
+			LOOP
+				EXECUTE format('ALTER TABLE ` + table + ` ALTER COLUMN %I TYPE TIMESTAMPTZ USING %I AT TIME ZONE current_setting(''TimeZone'')', col.column_name, col.column_name);
+			END LOOP;
+		END $$`
+}
+
+// logUnmigratedAccounts warns about profiles that could not be linked to a user
+// because their email is already used by another account. They need to be
+// merged or renamed by hand.
+func logUnmigratedAccounts(conn *pgxpool.Pool) {
+	for _, table := range []string{"admin_info", "doctor_info", "patient_info"} {
+		var count int
+		err := conn.QueryRow(context.Background(), "SELECT COUNT(*) FROM "+table+" WHERE user_id IS NULL").Scan(&count)
+		if err != nil {
+			log.Printf("Failed to check unmigrated accounts in %s: %v", table, err)
+			continue
+		}
+		if count > 0 {
+			log.Printf("Warning: %d rows in %s have no user account, their email is already used by another account", count, table)
+		}
+	}
+}
+
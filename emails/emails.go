@@ -0,0 +1,201 @@
+// Package emails renders the transactional emails from the templates embedded
+// in templates/. Every email exists in each supported language and has a
+// subject, a plain text body and an HTML body.
+package emails
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	"tbibi_back_end_go/mailer"
+	texttemplate "text/template"
+	"time"
+)
+
+// Names of the emails
+const (
+	Verification           = "verification"
+	PasswordReset          = "password_reset"
+	AppointmentConfirmed   = "appointment_confirmed"
+	AppointmentCancelled   = "appointment_cancelled"
+	AppointmentRescheduled = "appointment_rescheduled"
+	ItemShared             = "item_shared"
+)
+
+// Names lists every email, for the admin preview
+var Names = []string{Verification, PasswordReset, AppointmentConfirmed, AppointmentCancelled, AppointmentRescheduled, ItemShared}
+
+// DefaultLanguage is used when a user has no preference or prefers a language we have no templates for
+const DefaultLanguage = "en"
+
+// Languages lists the languages every email is translated to
+var Languages = []string{"en", "fr", "ar"}
+
+var rightToLeft = map[string]bool{"ar": true}
+
+// Data of each email
+type VerificationData struct {
+	Link           string
+	ExpiresInHours int
+}
+
+type PasswordResetData struct {
+	Link             string
+	ExpiresInMinutes int
+}
+
+type AppointmentData struct {
+	RecipientName string
+	DoctorName    string
+	PatientName   string
+	Title         string
+	Reason        string
+	Start         time.Time
+	End           time.Time
+	// the old start of a rescheduled appointment
+	PreviousStart time.Time
+}
+
+type ItemSharedData struct {
+	RecipientName string
+	SharerName    string
+	ItemNames     []string
+}
+
+//go:embed templates
+var templateFS embed.FS
+
+type templateData struct {
+	Lang  string
+	Dir   string
+	Align string
+	Data  interface{}
+}
+
+type button struct {
+	URL   string
+	Label string
+}
+
+var funcs = map[string]interface{}{
+	"datetime": func(t time.Time) string { return t.Format("2006-01-02 15:04 MST") },
+	"button":   func(url string, label string) button { return button{URL: url, Label: label} },
+}
+
+type emailTemplates struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// templates by language and then by email name, parsed once at startup
+var templates = loadTemplates()
+
+func loadTemplates() map[string]map[string]emailTemplates {
+	all := map[string]map[string]emailTemplates{}
+	for _, lang := range Languages {
+		all[lang] = map[string]emailTemplates{}
+		for _, name := range Names {
+			files := []string{"templates/" + lang + "/common.tmpl", "templates/" + lang + "/" + name + ".tmpl"}
+			text := texttemplate.Must(texttemplate.New(name).Funcs(funcs).ParseFS(templateFS, files...))
+			html := htmltemplate.Must(htmltemplate.New("layout.html").Funcs(funcs).ParseFS(templateFS,
+				append([]string{"templates/layout.html", "templates/button.html"}, files...)...))
+			all[lang][name] = emailTemplates{text: text, html: html}
+		}
+	}
+	return all
+}
+
+// SupportedLanguage maps a language tag such as "fr-FR" to one of Languages, falling back to DefaultLanguage
+func SupportedLanguage(lang string) string {
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	if i := strings.IndexAny(lang, "-_"); i >= 0 {
+		lang = lang[:i]
+	}
+	if _, ok := templates[lang]; ok {
+		return lang
+	}
+	return DefaultLanguage
+}
+
+// LanguageFromRequest picks the language of a new account, the one it asked for
+// or else the first supported one of its Accept-Language header
+func LanguageFromRequest(requested string, acceptLanguage string) string {
+	if requested != "" {
+		return SupportedLanguage(requested)
+	}
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag := strings.TrimSpace(strings.SplitN(part, ";", 2)[0])
+		if tag == "" {
+			continue
+		}
+		if lang := SupportedLanguage(tag); lang != DefaultLanguage || strings.HasPrefix(strings.ToLower(tag), DefaultLanguage) {
+			return lang
+		}
+	}
+	return DefaultLanguage
+}
+
+// Render renders an email in the given language, the returned message has no recipient yet
+func Render(name string, lang string, data interface{}) (mailer.Message, error) {
+	lang = SupportedLanguage(lang)
+	tmpl, ok := templates[lang][name]
+	if !ok {
+		return mailer.Message{}, fmt.Errorf("unknown email %q", name)
+	}
+
+	values := templateData{Lang: lang, Dir: "ltr", Align: "left", Data: data}
+	if rightToLeft[lang] {
+		values.Dir, values.Align = "rtl", "right"
+	}
+
+	var subject, text, html bytes.Buffer
+	if err := tmpl.text.ExecuteTemplate(&subject, "subject", values); err != nil {
+		return mailer.Message{}, err
+	}
+	if err := tmpl.text.ExecuteTemplate(&text, "text", values); err != nil {
+		return mailer.Message{}, err
+	}
+	if err := tmpl.html.Execute(&html, values); err != nil {
+		return mailer.Message{}, err
+	}
+
+	return mailer.Message{
+		Subject:  strings.TrimSpace(subject.String()),
+		TextBody: strings.TrimSpace(text.String()) + "\n",
+		HTMLBody: html.String(),
+	}, nil
+}
+
+// Build renders an email for a recipient
+func Build(to string, lang string, name string, data interface{}) (mailer.Message, error) {
+	msg, err := Render(name, lang, data)
+	msg.To = to
+	return msg, err
+}
+
+// SampleData returns example data for an email, used by the admin preview
+func SampleData(name string) (interface{}, bool) {
+	start := time.Date(2025, time.March, 14, 10, 30, 0, 0, time.UTC)
+	switch name {
+	case Verification:
+		return VerificationData{Link: "https://example.com/activate_account?token=sample", ExpiresInHours: 24}, true
+	case PasswordReset:
+		return PasswordResetData{Link: "https://example.com/reset-password?token=sample", ExpiresInMinutes: 60}, true
+	case AppointmentConfirmed, AppointmentCancelled, AppointmentRescheduled:
+		return AppointmentData{
+			RecipientName: "Amina Benali",
+			DoctorName:    "Karim Haddad",
+			PatientName:   "Amina Benali",
+			Title:         "Follow-up consultation",
+			Reason:        "The doctor is unavailable",
+			Start:         start,
+			End:           start.Add(30 * time.Minute),
+			PreviousStart: start.AddDate(0, 0, -2),
+		}, true
+	case ItemShared:
+		return ItemSharedData{RecipientName: "Karim Haddad", SharerName: "Amina Benali", ItemNames: []string{"Blood test results.pdf", "X-rays"}}, true
+	}
+	return nil, false
+}
@@ -0,0 +1,98 @@
+package emails
+
+import (
+	"strings"
+	"testing"
+)
+
+// mustContain are values of the sample data each email shows in both bodies
+var mustContain = map[string][]string{
+	Verification:           {"https://example.com/activate_account?token=sample"},
+	PasswordReset:          {"https://example.com/reset-password?token=sample"},
+	AppointmentConfirmed:   {"Amina Benali", "Karim Haddad", "2025-03-14 10:30"},
+	AppointmentCancelled:   {"Amina Benali", "Karim Haddad", "2025-03-14 10:30", "The doctor is unavailable"},
+	AppointmentRescheduled: {"Amina Benali", "Karim Haddad", "2025-03-12 10:30", "2025-03-14 10:30"},
+	ItemShared:             {"Karim Haddad", "Amina Benali", "Blood test results.pdf", "X-rays"},
+}
+
+func TestRenderEveryEmailInEveryLanguage(t *testing.T) {
+	for _, name := range Names {
+		data, ok := SampleData(name)
+		if !ok {
+			t.Fatalf("%s: no sample data", name)
+		}
+		subjects := map[string]string{}
+
+		for _, lang := range Languages {
+			t.Run(name+"/"+lang, func(t *testing.T) {
+				msg, err := Render(name, lang, data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if msg.Subject == "" || strings.Contains(msg.Subject, "\n") {
+					t.Errorf("subject %q is empty or spans lines", msg.Subject)
+				}
+				subjects[lang] = msg.Subject
+
+				for _, body := range []string{msg.Subject, msg.TextBody, msg.HTMLBody} {
+					if strings.Contains(body, "<no value>") {
+						t.Errorf("missing value in %q", body)
+					}
+				}
+				for _, value := range mustContain[name] {
+					if !strings.Contains(msg.TextBody, value) {
+						t.Errorf("text body does not contain %q", value)
+					}
+					if !strings.Contains(msg.HTMLBody, value) {
+						t.Errorf("HTML body does not contain %q", value)
+					}
+				}
+
+				dir := "ltr"
+				if lang == "ar" {
+					dir = "rtl"
+				}
+				if !strings.Contains(msg.HTMLBody, `<html lang="`+lang+`" dir="`+dir+`">`) {
+					t.Errorf("HTML body is not marked as %s %s", lang, dir)
+				}
+			})
+		}
+
+		if subjects["en"] == subjects["fr"] || subjects["en"] == subjects["ar"] {
+			t.Errorf("%s: subject is not translated: %v", name, subjects)
+		}
+	}
+}
+
+func TestRenderEscapesHTML(t *testing.T) {
+	msg, err := Render(ItemShared, "en", ItemSharedData{RecipientName: "Karim", SharerName: "<b>Amina</b>", ItemNames: []string{"<script>x</script>"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(msg.HTMLBody, "<script>") || strings.Contains(msg.HTMLBody, "<b>Amina") {
+		t.Error("HTML body contains unescaped user input")
+	}
+	if !strings.Contains(msg.TextBody, "<b>Amina</b>") {
+		t.Error("text body was escaped")
+	}
+}
+
+func TestRenderFallsBackToDefaultLanguage(t *testing.T) {
+	data, _ := SampleData(Verification)
+	english, err := Render(Verification, "en", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, lang := range []string{"de", "", "en-GB"} {
+		msg, err := Render(Verification, lang, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Subject != english.Subject {
+			t.Errorf("%q: subject %q, want the English one", lang, msg.Subject)
+		}
+	}
+	if _, err := Render("unknown", "en", data); err == nil {
+		t.Error("unknown email rendered")
+	}
+}
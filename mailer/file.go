@@ -0,0 +1,46 @@
+package mailer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileMailer delivers emails into a maildir instead of sending them, any mail
+// client that reads maildirs can open it
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+// NewFileMailer creates the maildir layout under dir if needed
+func NewFileMailer(dir string, from string) (*FileMailer, error) {
+	if from == "" {
+		from = "no-reply@tbibi.local"
+	}
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, err
+		}
+	}
+	return &FileMailer{Dir: dir, From: from}, nil
+}
+
+// Send writes the email to tmp and then moves it into new, as the maildir format requires
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d.%s.tbibi.eml", time.Now().UnixNano(), hex.EncodeToString(suffix))
+
+	tmpPath := filepath.Join(m.Dir, "tmp", name)
+	if err := os.WriteFile(tmpPath, msg.format(m.From), 0644); err != nil {
+		return fmt.Errorf("failed to write email to %s: %v", tmpPath, err)
+	}
+	return os.Rename(tmpPath, filepath.Join(m.Dir, "new", name))
+}
@@ -0,0 +1,107 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"os"
+	"strings"
+	"time"
+)
+
+// Message is an email to a single recipient. When HTMLBody is set the email is
+// sent as multipart/alternative with TextBody as the plain text part.
+type Message struct {
+	To       string
+	Subject  string
+	TextBody string
+	HTMLBody string
+}
+
+// Mailer sends emails. Services receive one from the route setup instead of
+// talking to an SMTP server themselves, so that the backend can be swapped.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// FromEnv builds the mailer selected by MAILER_BACKEND:
+//   - smtp (the default when SMTP_HOST is set) uses SMTP_HOST, SMTP_PORT, SMTP_EMAIL and SMTP_EMAIL_PASSWORD
+//   - file writes every email into the maildir MAIL_DIR, ./mail by default, for local development
+//   - memory keeps the emails in memory
+func FromEnv() (Mailer, error) {
+	backend := strings.ToLower(os.Getenv("MAILER_BACKEND"))
+	if backend == "" {
+		backend = "file"
+		if os.Getenv("SMTP_HOST") != "" {
+			backend = "smtp"
+		}
+	}
+
+	switch backend {
+	case "smtp":
+		mailer := &SMTPMailer{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     os.Getenv("SMTP_PORT"),
+			Username: os.Getenv("SMTP_EMAIL"),
+			Password: os.Getenv("SMTP_EMAIL_PASSWORD"),
+			From:     os.Getenv("SMTP_EMAIL"),
+		}
+		if mailer.Host == "" || mailer.Port == "" || mailer.From == "" {
+			return nil, fmt.Errorf("the smtp mailer needs SMTP_HOST, SMTP_PORT and SMTP_EMAIL")
+		}
+		return mailer, nil
+	case "file":
+		dir := os.Getenv("MAIL_DIR")
+		if dir == "" {
+			dir = "./mail"
+		}
+		return NewFileMailer(dir, os.Getenv("SMTP_EMAIL"))
+	case "memory":
+		return NewMemoryMailer(), nil
+	default:
+		return nil, fmt.Errorf("unknown MAILER_BACKEND %q", backend)
+	}
+}
+
+// format renders the message as an RFC 5322 email
+func (msg Message) format(from string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("From: " + from + "\r\n")
+	buf.WriteString("To: " + msg.To + "\r\n")
+	buf.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", msg.Subject) + "\r\n")
+	buf.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	buf.WriteString("MIME-Version: 1.0\r\n")
+
+	if msg.HTMLBody == "" {
+		buf.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+		buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		writeQuotedPrintable(&buf, msg.TextBody)
+		return buf.Bytes()
+	}
+
+	parts := multipart.NewWriter(&buf)
+	buf.WriteString("Content-Type: multipart/alternative; boundary=" + parts.Boundary() + "\r\n\r\n")
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain; charset=UTF-8", msg.TextBody},
+		{"text/html; charset=UTF-8", msg.HTMLBody},
+	} {
+		writer, _ := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		writeQuotedPrintable(writer, part.body)
+	}
+	parts.Close()
+	return buf.Bytes()
+}
+
+func writeQuotedPrintable(w io.Writer, body string) {
+	encoder := quotedprintable.NewWriter(w)
+	encoder.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n")))
+	encoder.Close()
+}
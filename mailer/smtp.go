@@ -0,0 +1,25 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"net/smtp"
+)
+
+// SMTPMailer sends emails through an SMTP server with PLAIN authentication
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	auth := smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	err := smtp.SendMail(m.Host+":"+m.Port, auth, m.From, []string{msg.To}, msg.format(m.From))
+	if err != nil {
+		return fmt.Errorf("failed to send email to %s: %v", msg.To, err)
+	}
+	return nil
+}
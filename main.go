@@ -1,11 +1,18 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"tbibi_back_end_go/apierrors"
+	"tbibi_back_end_go/auth"
 	"tbibi_back_end_go/db"
+	"tbibi_back_end_go/mailer"
+	"tbibi_back_end_go/outbox"
 	"tbibi_back_end_go/routes"
+	"tbibi_back_end_go/schedule"
 	"tbibi_back_end_go/services"
+	"tbibi_back_end_go/validators"
 	"time"
 
 	"github.com/gin-contrib/cors"
@@ -15,11 +22,14 @@ import (
 func main() {
 	r := gin.Default()
 
+	// Every request gets an ID, error responses and logs carry it
+	r.Use(apierrors.RequestID())
+
 	config := cors.Config{
 		AllowOrigins: []string{"http://localhost:3000", "http://10.134.32.128:3000"},
         AllowMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-        AllowHeaders: []string{"Origin", "Content-Type", "Content-Length"},
-        ExposeHeaders:    []string{"Content-Length"},
+        AllowHeaders: []string{"Origin", "Content-Type", "Content-Length", "Authorization", apierrors.RequestIDHeader},
+        ExposeHeaders:    []string{"Content-Length", "Retry-After", apierrors.RequestIDHeader},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}
@@ -52,7 +62,39 @@ func main() {
 	
 	defer conn.Close()
 
-	r.GET("/ws", services.ServeWs)
+	// Load the JWT signing keys
+	if err := auth.LoadKeysFromEnv(); err != nil {
+		log.Fatalf("Failed to load JWT keys: %v", err)
+	}
+
+	// Create the first admin account if configured
+	if err := services.EnsureBootstrapAdmin(conn); err != nil {
+		log.Fatalf("Failed to create the admin account: %v", err)
+	}
+
+	// Custom rules used by the binding tags of request structs
+	if err := validators.RegisterBindingValidators(); err != nil {
+		log.Fatalf("Failed to register request validators: %v", err)
+	}
+
+	// Public address of the API used in activation links
+	if err := validators.LoadAPIBaseURL(); err != nil {
+		log.Fatalf("Failed to configure the API address: %v", err)
+	}
+
+	// Email backend, see mailer.FromEnv. Requests only queue emails, the outbox worker sends them.
+	mail, err := mailer.FromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure the mailer: %v", err)
+	}
+	outbox.StartWorker(context.Background(), conn, mail)
+
+	// Keeps the slots of recurring schedules generated ahead
+	schedule.StartGenerator(context.Background(), conn)
+
+	r.GET("/ws", auth.AuthMiddleware(), func(c *gin.Context) {
+		services.ServeWs(c, conn)
+	})
 
 	// Initialize routes
 	routes.SetupPatientRoutes(r, conn)
@@ -62,6 +104,12 @@ func main() {
 	routes.SetupAccountValidationRoutes(r, conn)
 	routes.SetupShareRoutes(r, conn)
 	routes.SetupChatRoutes(r, conn)
+	routes.SetupAuthRoutes(r, conn)
+	routes.SetupAdminRoutes(r, conn)
+
+	r.NoRoute(func(c *gin.Context) {
+		apierrors.Abort(c, apierrors.CodeNotFound, "Route not found")
+	})
 
 
 
@@ -9,16 +9,57 @@ type Availability struct {
 	DoctorID          string    `json:"DoctorId"`
 }
 
+// Statuses of an appointment
+const (
+	AppointmentBooked    = "booked"
+	AppointmentCancelled = "cancelled"
+	AppointmentCompleted = "completed"
+	AppointmentNoShow    = "no_show"
+)
+
 type Reservation struct {
-	ReservationID    int       `json:"reservation_id"`
-	ReservationStart time.Time `json:"reservation_start"`
-	ReservationEnd   time.Time `json:"reservation_end"`
-	DoctorFirstName  string    `json:"doctor_first_name"`
-	DoctorLastName   string    `json:"doctor_last_name"`
-	Specialty        string    `json:"specialty"`
-	PatientFirstName string    `json:"patient_first_name"`
-	PatientLastName  string    `json:"patient_last_name"`
-	Age              int       `json:"age"`
-	PatientID        string    `json:"patient_id"`
-	DoctorID         string    `json:"doctor_id"`
+	ReservationID      string    `json:"reservation_id"`
+	ReservationStart   time.Time `json:"reservation_start"`
+	ReservationEnd     time.Time `json:"reservation_end"`
+	DoctorFirstName    string    `json:"doctor_first_name"`
+	DoctorLastName     string    `json:"doctor_last_name"`
+	Specialty          string    `json:"specialty"`
+	PatientFirstName   string    `json:"patient_first_name"`
+	PatientLastName    string    `json:"patient_last_name"`
+	Age                int       `json:"age"`
+	PatientID          string    `json:"patient_id"`
+	DoctorID           string    `json:"doctor_id"`
+	Status             string    `json:"status"`
+	CancellationReason *string   `json:"cancellation_reason"`
+}
+
+// AvailabilitySlot is the body used to add or move a single availability slot
+type AvailabilitySlot struct {
+	AvailabilityStart time.Time `json:"AvailabilityStart" binding:"required"`
+	AvailabilityEnd   time.Time `json:"AvailabilityEnd" binding:"required,gtfield=AvailabilityStart"`
+}
+
+// AvailabilityRange splits From-To into consecutive slots of SlotMinutes separated by BreakMinutes
+type AvailabilityRange struct {
+	From          time.Time `json:"From" binding:"required"`
+	To            time.Time `json:"To" binding:"required,gtfield=From"`
+	SlotMinutes   int       `json:"SlotMinutes" binding:"required,min=5,max=720"`
+	BreakMinutes  int       `json:"BreakMinutes" binding:"min=0,max=720"`
+	SkipConflicts bool      `json:"SkipConflicts"`
+}
+
+// AppointmentCancellation is the body used to cancel an appointment
+type AppointmentCancellation struct {
+	Reason string `json:"reason" binding:"max=300"`
+}
+
+// AppointmentReschedule moves an appointment to another free slot of the same doctor
+type AppointmentReschedule struct {
+	AvailabilityID int    `json:"AvailabilityID" binding:"required,gt=0"`
+	Reason         string `json:"reason" binding:"max=300"`
+}
+
+// AppointmentOutcome is set by the doctor once an appointment has started
+type AppointmentOutcome struct {
+	Status string `json:"status" binding:"required,oneof=completed no_show"`
 }
@@ -1,31 +1,63 @@
 package models
 
+import "time"
+
 type Doctor struct {
-	DoctorID       string   `json:"DoctorId"`
-	Username       string   `json:"Username"`
-	FirstName      string   `json:"FirstName"`
-	LastName       string   `json:"LastName"`
-	Password       string   `json:"Password"`
-	Age            int      `json:"age"`
-	Sex            string   `json:"Sex"`
-	Specialty      string   `json:"Specialty"`
-	Experience     string   `json:"Experience"`
-	MedicalLicense string   `json:"MedicalLicense"`
-	DoctorBio      string   `json:"DoctorBio"`
-	Email          string   `json:"Email"`
-	PhoneNumber    string   `json:"PhoneNumber"`
-	StreetAddress  string   `json:"StreetAddress"`
-	CityName       string   `json:"CityName"`
-	StateName      string   `json:"StateName"`
-	ZipCode        string   `json:"ZipCode"`
-	CountryName    string   `json:"CountryName"`
-	BirthDate      string   `json:"BirthDate"`
-	Location       string   `json:"Location"`
-	RatingScore    *float32 `json:"RatingScore"`
-	RatingCount    int      `json:"RatingCount"`
+	DoctorID          string   `json:"DoctorId"`
+	Username          string   `json:"Username" binding:"required,username"`
+	FirstName         string   `json:"FirstName" binding:"required,notblank,max=50"`
+	LastName          string   `json:"LastName" binding:"required,notblank,max=50"`
+	Password          string   `json:"Password" binding:"required"`
+	Age               int      `json:"age"`
+	Sex               string   `json:"Sex" binding:"required,sex"`
+	Specialty         string   `json:"Specialty" binding:"required,notblank,max=50"`
+	Experience        string   `json:"Experience" binding:"required,notblank,max=50"`
+	MedicalLicense    string   `json:"MedicalLicense" binding:"required,notblank,max=50"`
+	DoctorBio         string   `json:"DoctorBio" binding:"max=50"`
+	Email             string   `json:"Email" binding:"required,email,max=50"`
+	PhoneNumber       string   `json:"PhoneNumber" binding:"required,phone"`
+	StreetAddress     string   `json:"StreetAddress" binding:"required,notblank,max=50"`
+	CityName          string   `json:"CityName" binding:"required,notblank,max=50"`
+	StateName         string   `json:"StateName" binding:"max=50"`
+	ZipCode           string   `json:"ZipCode" binding:"required,notblank,max=20"`
+	CountryName       string   `json:"CountryName" binding:"required,notblank,max=50"`
+	BirthDate         string   `json:"BirthDate" binding:"required,birthdate"`
+	Location          string   `json:"Location"`
+	RatingScore       *float32 `json:"RatingScore"`
+	RatingCount       int      `json:"RatingCount"`
+	PreferredLanguage string   `json:"PreferredLanguage" binding:"omitempty,language"`
+	TimeZone          string   `json:"TimeZone" binding:"omitempty,timezone"`
 }
 
+// DefaultPracticeTimeZone is the practice time zone of doctors who did not choose one
+const DefaultPracticeTimeZone = "Europe/Berlin"
+
 type LoginRequest struct {
-	Email    string `json:"email"`	
-	Password string `json:"password"`
-}
\ No newline at end of file
+	Email    string `json:"email" binding:"required,email"`
+	Password string `json:"password" binding:"required"`
+}
+
+// License review states of doctor_info.license_status
+const (
+	LicenseStatusPending  = "pending"
+	LicenseStatusApproved = "approved"
+	LicenseStatusRejected = "rejected"
+)
+
+// DoctorLicenseReview is what admins see when reviewing a doctor's medical license
+type DoctorLicenseReview struct {
+	DoctorID        string     `json:"DoctorId"`
+	FirstName       string     `json:"FirstName"`
+	LastName        string     `json:"LastName"`
+	Email           string     `json:"Email"`
+	PhoneNumber     string     `json:"PhoneNumber"`
+	Specialty       string     `json:"Specialty"`
+	Experience      string     `json:"Experience"`
+	MedicalLicense  string     `json:"MedicalLicense"`
+	Location        string     `json:"Location"`
+	EmailVerified   bool       `json:"EmailVerified"`
+	LicenseStatus   string     `json:"LicenseStatus"`
+	RejectionReason *string    `json:"RejectionReason,omitempty"`
+	ReviewedAt      *time.Time `json:"ReviewedAt,omitempty"`
+	RegisteredAt    time.Time  `json:"RegisteredAt"`
+}
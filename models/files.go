@@ -4,7 +4,7 @@ import "time"
 
 type FileFolder struct {
 	ID        string    `json:"folder_id"`
-	Name      string    `json:"name"`
+	Name      string    `json:"name" binding:"required,notblank,max=50,filename"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 	Type      string    `json:"file_type"`
@@ -12,6 +12,6 @@ type FileFolder struct {
 	Ext       *string    `json:"extension"`
 	UserID    string    `json:"user_id"`
 	UserType  string    `json:"user_type"`
-	ParentID *string `json:"parent_id,omitempty"`
+	ParentID *string `json:"parent_id,omitempty" binding:"omitempty,uuid"`
 	Path 	string    `json:"path"`
 }
@@ -1,20 +1,21 @@
 package models
 
 type Patient struct {
-	Username string `json:"Username"`
-	Password string `json:"Password"`
-	Email    string `json:"Email"`
+	Username string `json:"Username" binding:"required,username"`
+	Password string `json:"Password" binding:"required"`
+	Email    string `json:"Email" binding:"required,email,max=50"`
 	// Age           int    `json:"Age"`
-	PhoneNumber   string `json:"PhoneNumber"`
-	FirstName     string `json:"FirstName"`
-	LastName      string `json:"LastName"`
-	BirthDate     string `json:"BirthDate"`
-	StreetAddress string `json:"StreetAddress"`
-	CityName      string `json:"CityName"`
-	StateName     string `json:"StateName"`
-	ZipCode       string `json:"ZipCode"`
-	CountryName   string `json:"CountryName"`
-	PatientBio    string `json:"PatientBio"`
-	Sex           string `json:"sex"`
+	PhoneNumber       string `json:"PhoneNumber" binding:"required,phone"`
+	FirstName         string `json:"FirstName" binding:"required,notblank,max=50"`
+	LastName          string `json:"LastName" binding:"required,notblank,max=50"`
+	BirthDate         string `json:"BirthDate" binding:"required,birthdate"`
+	StreetAddress     string `json:"StreetAddress" binding:"required,notblank,max=50"`
+	CityName          string `json:"CityName" binding:"required,notblank,max=50"`
+	StateName         string `json:"StateName" binding:"max=50"`
+	ZipCode           string `json:"ZipCode" binding:"required,notblank,max=20"`
+	CountryName       string `json:"CountryName" binding:"required,notblank,max=50"`
+	PatientBio        string `json:"PatientBio" binding:"max=50"`
+	Sex               string `json:"sex" binding:"required,sex"`
+	PreferredLanguage string `json:"PreferredLanguage" binding:"omitempty,language"`
 	// Location      string `json:"location"`
 }
@@ -0,0 +1,37 @@
+package models
+
+// Profile updates are partial, fields left out of the request keep their value
+
+type PatientProfileUpdate struct {
+	Email         *string `json:"Email" binding:"omitempty,email,max=50"`
+	PhoneNumber   *string `json:"PhoneNumber" binding:"omitempty,phone"`
+	FirstName     *string `json:"FirstName" binding:"omitempty,max=50"`
+	LastName      *string `json:"LastName" binding:"omitempty,max=50"`
+	BirthDate     *string `json:"BirthDate" binding:"omitempty,birthdate"`
+	StreetAddress *string `json:"StreetAddress" binding:"omitempty,max=50"`
+	CityName      *string `json:"CityName" binding:"omitempty,max=50"`
+	StateName     *string `json:"StateName" binding:"omitempty,max=50"`
+	ZipCode       *string `json:"ZipCode" binding:"omitempty,max=20"`
+	CountryName   *string `json:"CountryName" binding:"omitempty,max=50"`
+	PatientBio    *string `json:"PatientBio" binding:"omitempty,max=50"`
+	Sex           *string `json:"sex" binding:"omitempty,sex"`
+}
+
+type DoctorProfileUpdate struct {
+	Email          *string `json:"Email" binding:"omitempty,email,max=50"`
+	PhoneNumber    *string `json:"PhoneNumber" binding:"omitempty,phone"`
+	FirstName      *string `json:"FirstName" binding:"omitempty,max=50"`
+	LastName       *string `json:"LastName" binding:"omitempty,max=50"`
+	BirthDate      *string `json:"BirthDate" binding:"omitempty,birthdate"`
+	StreetAddress  *string `json:"StreetAddress" binding:"omitempty,max=50"`
+	CityName       *string `json:"CityName" binding:"omitempty,max=50"`
+	StateName      *string `json:"StateName" binding:"omitempty,max=50"`
+	ZipCode        *string `json:"ZipCode" binding:"omitempty,max=20"`
+	CountryName    *string `json:"CountryName" binding:"omitempty,max=50"`
+	Sex            *string `json:"Sex" binding:"omitempty,sex"`
+	Specialty      *string `json:"Specialty" binding:"omitempty,max=50"`
+	Experience     *string `json:"Experience" binding:"omitempty,max=50"`
+	MedicalLicense *string `json:"MedicalLicense" binding:"omitempty,max=50"`
+	DoctorBio      *string `json:"DoctorBio" binding:"omitempty,max=50"`
+	TimeZone       *string `json:"TimeZone" binding:"omitempty,timezone"`
+}
@@ -0,0 +1,40 @@
+package models
+
+// ScheduleTemplate is a recurring weekly schedule. Weekdays uses 0 for Sunday,
+// times are HH:MM in TimeZone and dates are YYYY-MM-DD.
+type ScheduleTemplate struct {
+	ID             string  `json:"id"`
+	Weekdays       []int   `json:"weekdays"`
+	StartTime      string  `json:"start_time"`
+	EndTime        string  `json:"end_time"`
+	SlotMinutes    int     `json:"slot_minutes"`
+	BreakMinutes   int     `json:"break_minutes"`
+	TimeZone       string  `json:"time_zone"`
+	EffectiveFrom  string  `json:"effective_from"`
+	EffectiveUntil *string `json:"effective_until"`
+}
+
+// ScheduleTemplateRequest is the body used to create or replace a schedule template,
+// without a TimeZone it follows the doctor's practice time zone
+type ScheduleTemplateRequest struct {
+	Weekdays       []int   `json:"weekdays" binding:"required,min=1,max=7,dive,min=0,max=6"`
+	StartTime      string  `json:"start_time" binding:"required,datetime=15:04"`
+	EndTime        string  `json:"end_time" binding:"required,datetime=15:04"`
+	SlotMinutes    int     `json:"slot_minutes" binding:"required,min=5,max=720"`
+	BreakMinutes   int     `json:"break_minutes" binding:"min=0,max=720"`
+	TimeZone       string  `json:"time_zone" binding:"omitempty,timezone"`
+	EffectiveFrom  string  `json:"effective_from" binding:"required,datetime=2006-01-02"`
+	EffectiveUntil *string `json:"effective_until" binding:"omitempty,datetime=2006-01-02"`
+}
+
+// ScheduleException is a day off, no slots are generated on it
+type ScheduleException struct {
+	ID     string `json:"id"`
+	Date   string `json:"date"`
+	Reason string `json:"reason"`
+}
+
+type ScheduleExceptionRequest struct {
+	Date   string `json:"date" binding:"required,datetime=2006-01-02"`
+	Reason string `json:"reason" binding:"max=100"`
+}
@@ -0,0 +1,39 @@
+// Package outbox queues outgoing emails in the email_outbox table and delivers
+// them in the background. Services enqueue in the same transaction as the
+// change that triggers the email, so an email is sent if and only if that
+// change is committed, and no request waits on the mail server.
+package outbox
+
+import (
+	"context"
+	"math"
+	"tbibi_back_end_go/auth"
+	"tbibi_back_end_go/mailer"
+	"time"
+)
+
+// Delivery states of email_outbox.status
+const (
+	StatusPending = "pending"
+	StatusSent    = "sent"
+	StatusFailed  = "failed"
+)
+
+// Enqueue stores an email for delivery. Pass the transaction of the business change as db.
+func Enqueue(ctx context.Context, db auth.Querier, msg mailer.Message) error {
+	var id int64
+	return db.QueryRow(ctx, `
+		INSERT INTO email_outbox (recipient, subject, text_body, html_body, status, attempts, next_attempt_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, 0, NOW(), NOW())
+		RETURNING id`,
+		msg.To, msg.Subject, msg.TextBody, msg.HTMLBody, StatusPending).Scan(&id)
+}
+
+// retryDelay is the wait before the next attempt after the given number of failed ones
+func retryDelay(attempts int) time.Duration {
+	delay := time.Duration(float64(retryBase) * math.Pow(2, float64(attempts-1)))
+	if delay > retryMax || delay <= 0 {
+		return retryMax
+	}
+	return delay
+}
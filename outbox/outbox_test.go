@@ -0,0 +1,39 @@
+package outbox
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, retryBase},
+		{2, 2 * retryBase},
+		{3, 4 * retryBase},
+		{7, 64 * retryBase},
+		// 128 times retryBase is over an hour
+		{8, retryMax},
+		{50, retryMax},
+		{5000, retryMax},
+	}
+
+	for _, test := range tests {
+		if got := retryDelay(test.attempts); got != test.want {
+			t.Errorf("retryDelay(%d) = %v, want %v", test.attempts, got, test.want)
+		}
+	}
+}
+
+func TestRetryDelayGrows(t *testing.T) {
+	previous := time.Duration(0)
+	for attempts := 1; attempts <= maxAttempts; attempts++ {
+		delay := retryDelay(attempts)
+		if delay < previous || delay > retryMax {
+			t.Fatalf("retryDelay(%d) = %v after %v", attempts, delay, previous)
+		}
+		previous = delay
+	}
+}
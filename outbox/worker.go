@@ -0,0 +1,113 @@
+package outbox
+
+import (
+	"context"
+	"log"
+	"tbibi_back_end_go/mailer"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Delivery settings. An email is retried with exponential backoff, starting at
+// retryBase and capped at retryMax, and marked failed after maxAttempts.
+const (
+	pollInterval = 5 * time.Second
+	batchSize    = 20
+	maxAttempts  = 8
+	retryBase    = 30 * time.Second
+	retryMax     = time.Hour
+)
+
+type pendingEmail struct {
+	ID       int64
+	Attempts int
+	Message  mailer.Message
+}
+
+// StartWorker delivers queued emails with mail until ctx is cancelled. Several
+// workers, also in other processes, can run at once.
+func StartWorker(ctx context.Context, pool *pgxpool.Pool, mail mailer.Mailer) {
+	go func() {
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		for {
+			for {
+				delivered, err := deliverBatch(ctx, pool, mail)
+				if err != nil {
+					log.Println("Email outbox error:", err)
+					break
+				}
+				if delivered < batchSize {
+					break
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// deliverBatch sends the emails that are due and returns how many it handled.
+// Rows are locked with SKIP LOCKED so that concurrent workers never send the same email.
+func deliverBatch(ctx context.Context, pool *pgxpool.Pool, mail mailer.Mailer) (int, error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		SELECT id, attempts, recipient, subject, text_body, html_body
+		FROM email_outbox
+		WHERE status = $1 AND next_attempt_at <= NOW()
+		ORDER BY next_attempt_at
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED`, StatusPending, batchSize)
+	if err != nil {
+		return 0, err
+	}
+	emails := []pendingEmail{}
+	for rows.Next() {
+		var email pendingEmail
+		if err := rows.Scan(&email.ID, &email.Attempts, &email.Message.To, &email.Message.Subject, &email.Message.TextBody, &email.Message.HTMLBody); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		emails = append(emails, email)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, email := range emails {
+		sendErr := mail.Send(ctx, email.Message)
+		attempts := email.Attempts + 1
+
+		switch {
+		case sendErr == nil:
+			_, err = tx.Exec(ctx,
+				"UPDATE email_outbox SET status = $1, attempts = $2, sent_at = NOW(), last_error = NULL WHERE id = $3",
+				StatusSent, attempts, email.ID)
+		case attempts >= maxAttempts:
+			log.Printf("Giving up on email %d to %s after %d attempts: %v", email.ID, email.Message.To, attempts, sendErr)
+			_, err = tx.Exec(ctx,
+				"UPDATE email_outbox SET status = $1, attempts = $2, last_error = $3, failed_at = NOW() WHERE id = $4",
+				StatusFailed, attempts, sendErr.Error(), email.ID)
+		default:
+			_, err = tx.Exec(ctx,
+				"UPDATE email_outbox SET attempts = $1, last_error = $2, next_attempt_at = NOW() + make_interval(secs => $3) WHERE id = $4",
+				attempts, sendErr.Error(), retryDelay(attempts).Seconds(), email.ID)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(emails), tx.Commit(ctx)
+}
@@ -13,6 +13,10 @@ func SetupAccountValidationRoutes(r *gin.Engine, pool *pgxpool.Pool) {
 		})
 
 
+	r.POST("/api/v1/resend-verification", func(c *gin.Context) {
+		services.ResendVerification(c, pool)
+		})
+
 	r.POST("/api/v1/request-reset", func(c *gin.Context) {
 		services.RequestReset(c, pool)
 		})
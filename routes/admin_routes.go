@@ -0,0 +1,55 @@
+package routes
+
+import (
+	"tbibi_back_end_go/auth"
+	"tbibi_back_end_go/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+func SetupAdminRoutes(r *gin.Engine, pool *pgxpool.Pool) {
+	r.POST("/api/v1/admins/login", func(c *gin.Context) {
+		services.LoginAdmin(c, pool)
+	})
+
+	admin := r.Group("/api/v1/admin", auth.AuthMiddleware(), auth.RequireRoles(auth.RoleAdmin))
+
+	admin.GET("/doctors/pending", func(c *gin.Context) {
+		services.ListPendingDoctors(c, pool)
+	})
+
+	admin.GET("/doctors/:doctorId/license", func(c *gin.Context) {
+		services.GetDoctorLicense(c, pool)
+	})
+
+	admin.POST("/doctors/:doctorId/approve", func(c *gin.Context) {
+		services.ApproveDoctor(c, pool)
+	})
+
+	admin.POST("/doctors/:doctorId/reject", func(c *gin.Context) {
+		services.RejectDoctor(c, pool)
+	})
+
+	admin.GET("/login-attempts", func(c *gin.Context) {
+		services.ListLoginAttempts(c, pool)
+	})
+
+	admin.POST("/users/:userId/2fa/require", func(c *gin.Context) {
+		services.RequireTwoFactor(c, pool)
+	})
+
+	admin.POST("/users/:userId/2fa/reset", func(c *gin.Context) {
+		services.ResetTwoFactor(c, pool)
+	})
+
+	admin.GET("/emails/:template/preview", services.PreviewEmail)
+
+	admin.GET("/email-outbox", func(c *gin.Context) {
+		services.ListOutboxEmails(c, pool)
+	})
+
+	admin.POST("/email-outbox/:emailId/retry", func(c *gin.Context) {
+		services.RetryOutboxEmail(c, pool)
+	})
+}
@@ -1,6 +1,7 @@
 package routes
 
 import (
+	"tbibi_back_end_go/auth"
 	"tbibi_back_end_go/services"
 
 	"github.com/gin-gonic/gin"
@@ -11,18 +12,82 @@ import (
 
 
 func SetupAppointmentManagementRoutes(r *gin.Engine, pool *pgxpool.Pool) {
-	r.GET("/api/v1/availabilities", func(c *gin.Context) {
+	protected := r.Group("", auth.AuthMiddleware())
+
+	protected.GET("/api/v1/availabilities", func(c *gin.Context) {
 		services.GetAvailabilities(c, pool)
 	})
 
-	r.POST("/api/v1/reservations", func(c *gin.Context) {
+	// Doctors manage their own slots
+	doctors := protected.Group("/api/v1/availabilities", auth.RequireRoles(auth.RoleDoctor))
+
+	doctors.POST("", func(c *gin.Context) {
+		services.CreateAvailability(c, pool)
+	})
+
+	doctors.POST("/bulk", func(c *gin.Context) {
+		services.CreateAvailabilities(c, pool)
+	})
+
+	doctors.PUT("/:availabilityId", auth.RequireOwnership(auth.OwnsAvailability(pool, "availabilityId")), func(c *gin.Context) {
+		services.UpdateAvailability(c, pool)
+	})
+
+	doctors.DELETE("/:availabilityId", auth.RequireOwnership(auth.OwnsAvailability(pool, "availabilityId")), func(c *gin.Context) {
+		services.DeleteAvailability(c, pool)
+	})
+
+	// Recurring weekly schedules generate the doctor's slots
+	schedules := protected.Group("/api/v1/schedules", auth.RequireRoles(auth.RoleDoctor))
+
+	schedules.GET("", func(c *gin.Context) {
+		services.GetSchedule(c, pool)
+	})
+
+	schedules.POST("/templates", func(c *gin.Context) {
+		services.CreateScheduleTemplate(c, pool)
+	})
+
+	schedules.PUT("/templates/:templateId", func(c *gin.Context) {
+		services.UpdateScheduleTemplate(c, pool)
+	})
+
+	schedules.DELETE("/templates/:templateId", func(c *gin.Context) {
+		services.DeleteScheduleTemplate(c, pool)
+	})
+
+	schedules.POST("/exceptions", func(c *gin.Context) {
+		services.CreateScheduleException(c, pool)
+	})
+
+	schedules.DELETE("/exceptions/:exceptionId", func(c *gin.Context) {
+		services.DeleteScheduleException(c, pool)
+	})
+
+	protected.POST("/api/v1/reservations", auth.RequireRoles(auth.RolePatient), func(c *gin.Context) {
 		services.CreateReservation(c, pool)
 	})
 
 
-	r.GET("/api/v1/reservations", func(c *gin.Context) {
+	protected.GET("/api/v1/reservations", auth.RequireRoles(auth.RolePatient, auth.RoleDoctor), func(c *gin.Context) {
 		services.GetReservations(c, pool)
 	})
 
+	// Both parties can cancel or move an appointment, only the doctor records its outcome
+	reservations := protected.Group("/api/v1/reservations/:appointmentId",
+		auth.RequireRoles(auth.RolePatient, auth.RoleDoctor), auth.RequireOwnership(auth.ParticipantOfAppointment(pool, "appointmentId")))
+
+	reservations.POST("/cancel", func(c *gin.Context) {
+		services.CancelAppointment(c, pool)
+	})
+
+	reservations.POST("/reschedule", func(c *gin.Context) {
+		services.RescheduleAppointment(c, pool)
+	})
+
+	reservations.PUT("/status", auth.RequireRoles(auth.RoleDoctor), func(c *gin.Context) {
+		services.SetAppointmentOutcome(c, pool)
+	})
+
 }
 
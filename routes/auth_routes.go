@@ -0,0 +1,78 @@
+package routes
+
+import (
+	"tbibi_back_end_go/auth"
+	"tbibi_back_end_go/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+func SetupAuthRoutes(r *gin.Engine, pool *pgxpool.Pool) {
+	r.GET("/.well-known/jwks.json", auth.JWKSHandler)
+
+	r.POST("/api/v1/auth/login", func(c *gin.Context) {
+		services.Login(c, pool)
+	})
+
+	r.POST("/api/v1/auth/refresh", func(c *gin.Context) {
+		services.RefreshSession(c, pool)
+	})
+
+	r.POST("/api/v1/auth/logout", func(c *gin.Context) {
+		services.Logout(c, pool)
+	})
+
+	r.POST("/api/v1/auth/2fa/verify", func(c *gin.Context) {
+		services.VerifyTwoFactor(c, pool)
+	})
+
+	// Enrollment also accepts the mfa token of a login that requires 2FA to be set up first
+	enrollment := r.Group("/api/v1/auth/2fa", auth.EnrollmentMiddleware())
+
+	enrollment.POST("/setup", func(c *gin.Context) {
+		services.SetupTwoFactor(c, pool)
+	})
+
+	enrollment.POST("/confirm", func(c *gin.Context) {
+		services.ConfirmTwoFactor(c, pool)
+	})
+
+	protected := r.Group("", auth.AuthMiddleware())
+
+	protected.GET("/api/v1/auth/sessions", func(c *gin.Context) {
+		services.ListSessions(c, pool)
+	})
+
+	protected.DELETE("/api/v1/auth/sessions", func(c *gin.Context) {
+		services.RevokeOtherSessions(c, pool)
+	})
+
+	protected.DELETE("/api/v1/auth/sessions/:sessionId", func(c *gin.Context) {
+		services.RevokeSession(c, pool)
+	})
+
+	protected.GET("/api/v1/auth/2fa", func(c *gin.Context) {
+		services.GetTwoFactorStatus(c, pool)
+	})
+
+	protected.POST("/api/v1/auth/2fa/disable", func(c *gin.Context) {
+		services.DisableTwoFactor(c, pool)
+	})
+
+	protected.POST("/api/v1/auth/2fa/recovery-codes", func(c *gin.Context) {
+		services.RegenerateRecoveryCodes(c, pool)
+	})
+
+	protected.PUT("/api/v1/auth/language", func(c *gin.Context) {
+		services.UpdatePreferredLanguage(c, pool)
+	})
+
+	protected.GET("/api/v1/account/export", auth.RequireRoles(auth.RolePatient, auth.RoleDoctor), func(c *gin.Context) {
+		services.ExportAccountData(c, pool)
+	})
+
+	protected.DELETE("/api/v1/account", auth.RequireRoles(auth.RolePatient, auth.RoleDoctor), func(c *gin.Context) {
+		services.DeleteAccount(c, pool)
+	})
+}
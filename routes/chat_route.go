@@ -2,7 +2,8 @@ package routes
 
 import (
 	"context"
-	"net/http"
+	"tbibi_back_end_go/apierrors"
+	"tbibi_back_end_go/auth"
 	"tbibi_back_end_go/services"
 
 	"github.com/gin-gonic/gin"
@@ -12,16 +13,18 @@ import (
 
 
 func SetupChatRoutes(r *gin.Engine, pool *pgxpool.Pool) {
+	protected := r.Group("", auth.AuthMiddleware())
+
 	// Endpoint to search users
-	r.GET("/api/v1/search/:username", func(c *gin.Context) {
+	protected.GET("/api/v1/search/:username", func(c *gin.Context) {
 		services.SearchUsers(c, pool)
 	})
 
 	// Endpoint to retrieve messages for a specific chat
-	r.GET("/api/v1/messages/:chatId", func(c *gin.Context) {
+	protected.GET("/api/v1/messages/:chatId", auth.RequireOwnership(auth.ParticipantOfChat(pool, "chatId")), func(c *gin.Context) {
 		conn, err := pool.Acquire(context.Background())
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to acquire a database connection"})
+			apierrors.Abort(c, apierrors.CodeInternal, "Failed to acquire a database connection")
 			return
 		}
 		defer conn.Release()
@@ -29,10 +32,10 @@ func SetupChatRoutes(r *gin.Engine, pool *pgxpool.Pool) {
 	})
 
 	// Endpoint to create or find an existing chat between two users
-	r.GET("/api/findOrCreateChat", func(c *gin.Context) {
+	protected.GET("/api/findOrCreateChat", func(c *gin.Context) {
 		conn, err := pool.Acquire(context.Background())
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to acquire a database connection"})
+				apierrors.Abort(c, apierrors.CodeInternal, "Failed to acquire a database connection")
 				return
 			}
 			defer conn.Release()
@@ -41,20 +44,20 @@ func SetupChatRoutes(r *gin.Engine, pool *pgxpool.Pool) {
 	
 
 
-	r.GET("/api/v1/chats", func(c *gin.Context) {
+	protected.GET("/api/v1/chats", func(c *gin.Context) {
 		conn, err := pool.Acquire(context.Background())
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to acquire a database connection"})
+			apierrors.Abort(c, apierrors.CodeInternal, "Failed to acquire a database connection")
 			return
 		}
 		defer conn.Release()
 		services.ListChatsForUser(conn.Conn(), c)
 	})
 
-	r.POST("/api/v1/SendMessage", func(c *gin.Context) {
+	protected.POST("/api/v1/SendMessage", func(c *gin.Context) {
 		conn, err := pool.Acquire(context.Background())
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to acquire a database connection"})
+			apierrors.Abort(c, apierrors.CodeInternal, "Failed to acquire a database connection")
 			return
 		}
 		defer conn.Release()
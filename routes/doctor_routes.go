@@ -1,6 +1,7 @@
 package routes
 
 import (
+	"tbibi_back_end_go/auth"
 	"tbibi_back_end_go/services"
 
 	"github.com/gin-gonic/gin"
@@ -19,11 +20,17 @@ func SetupDoctorRoutes(r *gin.Engine, pool *pgxpool.Pool) {
 		services.LoginDoctor(c, pool)
 	})
 
-	r.GET("/api/v1/doctors/:doctorId", func(c *gin.Context) {
+	protected := r.Group("", auth.AuthMiddleware())
+
+	protected.GET("/api/v1/doctors/:doctorId", func(c *gin.Context) {
 		services.GetDoctorById(c, pool)
 	})
 
-	r.GET("/api/v1/doctors", func(c *gin.Context) {
+	protected.PATCH("/api/v1/doctors/:doctorId", auth.RequireRoles(auth.RoleDoctor), auth.RequireOwnership(auth.SelfOrRoles("doctorId")), func(c *gin.Context) {
+		services.UpdateDoctorProfile(c, pool)
+	})
+
+	protected.GET("/api/v1/doctors", func(c *gin.Context) {
 		services.GetAllDoctors(c, pool)
 	})
 	
@@ -1,6 +1,7 @@
 package routes
 
 import (
+	"tbibi_back_end_go/auth"
 	"tbibi_back_end_go/services"
 
 	"github.com/gin-gonic/gin"
@@ -8,38 +9,42 @@ import (
 )
 
 func SetupFileRoutes(r *gin.Engine, pool *pgxpool.Pool) {
-	r.POST("/create-folder", func(c *gin.Context) {
+	protected := r.Group("", auth.AuthMiddleware())
+
+	protected.POST("/create-folder", auth.RequireRoles(auth.RolePatient, auth.RoleDoctor), func(c *gin.Context) {
 		services.CreateFolder(c, pool)
 	})
 
-	r.GET("/folders", func(c *gin.Context) {
+	protected.GET("/folders", func(c *gin.Context) {
 		services.GetFolders(c, pool)
 	})
 
-	r.GET("/folders/:folderId/subfolders", func(c *gin.Context) {
+	protected.GET("/folders/:folderId/subfolders", auth.RequireOwnership(auth.CanReadFolderFile(pool, "folderId")), func(c *gin.Context) {
 		services.GetSubfolders(c, pool)
 	})
 
-	r.GET("/folders/:folderId/breadcrumbs", func(c *gin.Context) {	
+	protected.GET("/folders/:folderId/breadcrumbs", auth.RequireOwnership(auth.CanReadFolderFile(pool, "folderId")), func(c *gin.Context) {	
 		services.GetBreadcrumbs(c, pool)
 	})
 	
-	r.DELETE("/delete-files/:folderId", func(c *gin.Context) {
+	protected.DELETE("/delete-files/:folderId", auth.RequireOwnership(auth.OwnsFolderFile(pool, "folderId")), func(c *gin.Context) {
     	services.DeleteFolderAndContents(c, pool)
 	})
 
-	r.PATCH("/update-folder/:folderId", func(c *gin.Context) {
+	protected.PATCH("/update-folder/:folderId", auth.RequireOwnership(auth.OwnsFolderFile(pool, "folderId")), func(c *gin.Context) {
 		services.UpdateFolderName(c, pool)
 	})
 
-	r.POST("/upload-file", func(c *gin.Context) {
+	protected.POST("/upload-file", auth.RequireRoles(auth.RolePatient, auth.RoleDoctor), func(c *gin.Context) {
 		services.UploadFile(c, pool)
 	})
 
-	r.Static("/files", "./uploads")
+	protected.GET("/files/*filepath", func(c *gin.Context) {
+		services.ServeUserFile(c)
+	})
 
-	r.GET("/download-file/:fileId", func(c *gin.Context) {
+	protected.GET("/download-file/:fileId", auth.RequireOwnership(auth.CanReadFolderFile(pool, "fileId")), func(c *gin.Context) {
         services.DownloadFile(c, pool)
     })
 
-}
\ No newline at end of file
+}
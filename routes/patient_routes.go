@@ -1,6 +1,7 @@
 package routes
 
 import (
+	"tbibi_back_end_go/auth"
 	"tbibi_back_end_go/services"
 
 	"github.com/gin-gonic/gin"
@@ -8,10 +9,16 @@ import (
 )
 
 func SetupPatientRoutes(r *gin.Engine, pool *pgxpool.Pool) {
-	r.GET("/api/v1/patients/:patientId", func(c *gin.Context) {
+	protected := r.Group("", auth.AuthMiddleware())
+
+	protected.GET("/api/v1/patients/:patientId", auth.RequireOwnership(auth.PatientOrTheirDoctor(pool, "patientId")), func(c *gin.Context) {
 		services.GetPatientById(c, pool)
 	})
 
+	protected.PATCH("/api/v1/patients/:patientId", auth.RequireRoles(auth.RolePatient), auth.RequireOwnership(auth.SelfOrRoles("patientId")), func(c *gin.Context) {
+		services.UpdatePatientProfile(c, pool)
+	})
+
 	r.POST("/api/v1/patients/register", func(c *gin.Context) {
 		services.RegisterPatient(c, pool)  
 	})
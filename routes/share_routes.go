@@ -1,6 +1,7 @@
 package routes
 
 import (
+	"tbibi_back_end_go/auth"
 	"tbibi_back_end_go/services"
 
 	"github.com/gin-gonic/gin"
@@ -8,20 +9,21 @@ import (
 )
 
 func SetupShareRoutes(r *gin.Engine, pool *pgxpool.Pool) {
+	protected := r.Group("", auth.AuthMiddleware())
 
-	r.POST("/api/v1/share", func(c *gin.Context) {
+	protected.POST("/api/v1/share", func(c *gin.Context) {
 		services.ShareItem(c, pool)
 	})
 
-	r.GET("/api/v1/shared-with-me", func(c *gin.Context) {
+	protected.GET("/api/v1/shared-with-me", func(c *gin.Context) {
 		services.GetSharedWithMe(c, pool)
 	})
 
-	r.GET("/api/v1/shared-by-me", func(c *gin.Context) {
+	protected.GET("/api/v1/shared-by-me", func(c *gin.Context) {
 		services.GetSharedByMe(c, pool)
 	})
 
-	r.GET("/api/v1/doctors-to-share-with", func(c *gin.Context) {
+	protected.GET("/api/v1/doctors-to-share-with", func(c *gin.Context) {
         services.ListDoctors(c, pool)
     })
 }	
\ No newline at end of file
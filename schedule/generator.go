@@ -0,0 +1,317 @@
+package schedule
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// How often the generator extends every doctor's slots up to Horizon
+const generateInterval = time.Hour
+
+// StartGenerator keeps the availabilities of every doctor with a schedule
+// generated up to Horizon ahead, until ctx is cancelled.
+func StartGenerator(ctx context.Context, pool *pgxpool.Pool) {
+	go func() {
+		ticker := time.NewTicker(generateInterval)
+		defer ticker.Stop()
+		for {
+			if err := regenerateAll(ctx, pool); err != nil {
+				log.Println("Schedule generator error:", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func regenerateAll(ctx context.Context, pool *pgxpool.Pool) error {
+	rows, err := pool.Query(ctx, "SELECT DISTINCT doctor_id::text FROM schedule_templates")
+	if err != nil {
+		return err
+	}
+	var doctorIDs []string
+	for rows.Next() {
+		var doctorID string
+		if err := rows.Scan(&doctorID); err != nil {
+			rows.Close()
+			return err
+		}
+		doctorIDs = append(doctorIDs, doctorID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, doctorID := range doctorIDs {
+		// one doctor's broken schedule does not stop the others
+		if err := regenerateDoctor(ctx, pool, doctorID); err != nil {
+			log.Printf("Error generating slots of doctor %s: %v", doctorID, err)
+		}
+	}
+	return nil
+}
+
+func regenerateDoctor(ctx context.Context, pool *pgxpool.Pool, doctorID string) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, _, err := Regenerate(ctx, tx, doctorID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// Regenerate brings the doctor's generated slots in line with their schedule,
+// from now up to Horizon. Generated slots the schedule no longer produces are
+// removed, missing ones are added unless they overlap another slot or an
+// appointment. Booked slots are appointments, they are never touched, and
+// occurrences the doctor deleted or moved by hand are not generated again.
+func Regenerate(ctx context.Context, tx pgx.Tx, doctorID string) (created int, removed int, err error) {
+	// same lock as the availability endpoints, so generated and manual slots cannot overlap
+	var id string
+	err = tx.QueryRow(ctx, "SELECT doctor_id::text FROM doctor_info WHERE doctor_id = $1 FOR UPDATE", doctorID).Scan(&id)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	now := time.Now().UTC()
+	if _, err := tx.Exec(ctx, "DELETE FROM schedule_skips WHERE doctor_id = $1 AND slot_start <= $2", doctorID, now); err != nil {
+		return 0, 0, err
+	}
+
+	templates, err := loadTemplates(ctx, tx, doctorID)
+	if err != nil {
+		return 0, 0, err
+	}
+	closedDates, err := loadClosedDates(ctx, tx, doctorID)
+	if err != nil {
+		return 0, 0, err
+	}
+	skipped, err := loadSkipped(ctx, tx, doctorID)
+	if err != nil {
+		return 0, 0, err
+	}
+	existing, err := loadGenerated(ctx, tx, doctorID, now)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var desired []Slot
+	for _, template := range templates {
+		templateSlots, err := template.Slots(now, now.Add(Horizon), closedDates)
+		if err != nil {
+			return 0, 0, err
+		}
+		desired = append(desired, templateSlots...)
+	}
+	missing, stale := diffSlots(desired, existing, skipped)
+
+	if len(stale) > 0 {
+		tag, err := tx.Exec(ctx, "DELETE FROM availabilities WHERE availability_id = ANY($1::int[])", stale)
+		if err != nil {
+			return 0, 0, err
+		}
+		removed = int(tag.RowsAffected())
+	}
+
+	if len(missing) > 0 {
+		templateIDs := make([]string, len(missing))
+		starts := make([]time.Time, len(missing))
+		ends := make([]time.Time, len(missing))
+		for i, slot := range missing {
+			templateIDs[i], starts[i], ends[i] = slot.TemplateID, slot.Start, slot.End
+		}
+
+		tag, err := tx.Exec(ctx, `
+			INSERT INTO availabilities (availability_start, availability_end, doctor_id, schedule_template_id)
+			SELECT s.start_at, s.end_at, $1, s.template_id::uuid
+			FROM unnest($2::text[], $3::timestamptz[], $4::timestamptz[]) AS s(template_id, start_at, end_at)
+			WHERE NOT EXISTS (SELECT 1 FROM availabilities a WHERE a.doctor_id = $1
+					AND a.availability_start < s.end_at AND a.availability_end > s.start_at)
+				AND NOT EXISTS (SELECT 1 FROM appointments p WHERE p.doctor_id = $1 AND p.status = 'booked'
+					AND p.appointment_start < s.end_at AND p.appointment_end > s.start_at)`,
+			doctorID, templateIDs, starts, ends)
+		if err != nil {
+			return 0, removed, err
+		}
+		created = int(tag.RowsAffected())
+	}
+	return created, removed, nil
+}
+
+// GeneratedSlot is a slot of a template that is already in availabilities
+type GeneratedSlot struct {
+	AvailabilityID int
+	Slot
+}
+
+// Occurrence identifies one slot of a template, independently of its length
+type Occurrence struct {
+	TemplateID string
+	Start      int64 // Unix seconds
+}
+
+func occurrenceOf(slot Slot) Occurrence {
+	return Occurrence{TemplateID: slot.TemplateID, Start: slot.Start.Unix()}
+}
+
+// diffSlots compares the slots the templates produce with the generated slots
+// in availabilities. It returns the slots to add and the availability IDs to
+// remove. Skipped occurrences, deleted or moved by the doctor, are never added.
+func diffSlots(desired []Slot, existing []GeneratedSlot, skipped map[Occurrence]bool) (missing []Slot, stale []int) {
+	var wanted []Slot
+	for _, slot := range desired {
+		if !skipped[occurrenceOf(slot)] {
+			wanted = append(wanted, slot)
+		}
+	}
+	wanted = withoutOverlaps(wanted)
+
+	// slots are matched on their template and times, a template with a new slot length replaces its slots
+	type slotKey struct {
+		occurrence Occurrence
+		end        int64
+	}
+	keyOf := func(slot Slot) slotKey { return slotKey{occurrenceOf(slot), slot.End.Unix()} }
+
+	kept := map[slotKey]bool{}
+	for _, slot := range wanted {
+		kept[keyOf(slot)] = true
+	}
+	present := map[slotKey]bool{}
+	for _, generated := range existing {
+		key := keyOf(generated.Slot)
+		if kept[key] && !present[key] {
+			present[key] = true
+			continue
+		}
+		stale = append(stale, generated.AvailabilityID)
+	}
+	for _, slot := range wanted {
+		if !present[keyOf(slot)] {
+			missing = append(missing, slot)
+		}
+	}
+	return missing, stale
+}
+
+// withoutOverlaps keeps the earliest of overlapping slots, when two templates cover the same hours
+func withoutOverlaps(slots []Slot) []Slot {
+	sort.SliceStable(slots, func(i, j int) bool { return slots[i].Start.Before(slots[j].Start) })
+	var kept []Slot
+	for _, slot := range slots {
+		if len(kept) > 0 && slot.Start.Before(kept[len(kept)-1].End) {
+			continue
+		}
+		kept = append(kept, slot)
+	}
+	return kept
+}
+
+// loadGenerated returns the doctor's future slots that came from a template
+func loadGenerated(ctx context.Context, tx pgx.Tx, doctorID string, now time.Time) ([]GeneratedSlot, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT availability_id, schedule_template_id::text, availability_start, availability_end FROM availabilities
+		WHERE doctor_id = $1 AND schedule_template_id IS NOT NULL AND availability_start > $2`, doctorID, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var generated []GeneratedSlot
+	for rows.Next() {
+		var slot GeneratedSlot
+		if err := rows.Scan(&slot.AvailabilityID, &slot.TemplateID, &slot.Start, &slot.End); err != nil {
+			return nil, err
+		}
+		generated = append(generated, slot)
+	}
+	return generated, rows.Err()
+}
+
+func loadSkipped(ctx context.Context, tx pgx.Tx, doctorID string) (map[Occurrence]bool, error) {
+	rows, err := tx.Query(ctx, "SELECT template_id::text, slot_start FROM schedule_skips WHERE doctor_id = $1", doctorID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	skipped := map[Occurrence]bool{}
+	for rows.Next() {
+		var templateID string
+		var start time.Time
+		if err := rows.Scan(&templateID, &start); err != nil {
+			return nil, err
+		}
+		skipped[Occurrence{TemplateID: templateID, Start: start.Unix()}] = true
+	}
+	return skipped, rows.Err()
+}
+
+// SkipOccurrence keeps the generator from producing again a template slot the
+// doctor deleted or moved by hand
+func SkipOccurrence(ctx context.Context, tx pgx.Tx, doctorID, templateID string, start time.Time) error {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO schedule_skips (doctor_id, template_id, slot_start) VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING`, doctorID, templateID, start)
+	return err
+}
+
+// loadTemplates returns the doctor's schedule templates, oldest first
+func loadTemplates(ctx context.Context, tx pgx.Tx, doctorID string) ([]Template, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT id::text, weekdays, to_char(start_time, 'HH24:MI'), to_char(end_time, 'HH24:MI'), slot_minutes, break_minutes,
+			time_zone, effective_from::text, effective_until::text
+		FROM schedule_templates WHERE doctor_id = $1 ORDER BY created_at`, doctorID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var templates []Template
+	for rows.Next() {
+		var template Template
+		var weekdays []int32
+		err := rows.Scan(&template.ID, &weekdays, &template.StartTime, &template.EndTime, &template.SlotMinutes, &template.BreakMinutes,
+			&template.TimeZone, &template.EffectiveFrom, &template.EffectiveUntil)
+		if err != nil {
+			return nil, err
+		}
+		for _, weekday := range weekdays {
+			template.Weekdays = append(template.Weekdays, time.Weekday(weekday))
+		}
+		templates = append(templates, template)
+	}
+	return templates, rows.Err()
+}
+
+func loadClosedDates(ctx context.Context, tx pgx.Tx, doctorID string) (map[string]bool, error) {
+	rows, err := tx.Query(ctx, "SELECT exception_date::text FROM schedule_exceptions WHERE doctor_id = $1 AND exception_date >= CURRENT_DATE - 1", doctorID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	closedDates := map[string]bool{}
+	for rows.Next() {
+		var date string
+		if err := rows.Scan(&date); err != nil {
+			return nil, err
+		}
+		closedDates[date] = true
+	}
+	return closedDates, rows.Err()
+}
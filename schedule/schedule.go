@@ -0,0 +1,86 @@
+// Package schedule turns doctors' recurring weekly schedules into availability slots.
+package schedule
+
+import (
+	"fmt"
+	"time"
+)
+
+// Horizon is how far ahead slots are materialized into availabilities
+const Horizon = 8 * 7 * 24 * time.Hour
+
+const (
+	dateFormat  = "2006-01-02"
+	clockFormat = "15:04"
+)
+
+// Template is a recurring weekly schedule, for instance Monday to Friday from
+// 09:00 to 12:00 in 30 minute slots. Times are wall clock times in TimeZone.
+type Template struct {
+	ID             string
+	Weekdays       []time.Weekday
+	StartTime      string // HH:MM
+	EndTime        string // HH:MM
+	SlotMinutes    int
+	BreakMinutes   int
+	TimeZone       string
+	EffectiveFrom  string  // YYYY-MM-DD
+	EffectiveUntil *string // YYYY-MM-DD, open ended when nil
+}
+
+// Slot is one generated availability, in UTC
+type Slot struct {
+	TemplateID string
+	Start      time.Time
+	End        time.Time
+}
+
+// Slots returns the template's slots that fit entirely between from and to,
+// leaving out the days listed in closedDates (YYYY-MM-DD, in the template's time zone)
+func (t Template) Slots(from, to time.Time, closedDates map[string]bool) ([]Slot, error) {
+	location, err := time.LoadLocation(t.TimeZone)
+	if err != nil {
+		return nil, fmt.Errorf("template %s: %v", t.ID, err)
+	}
+	dayStart, err := time.Parse(clockFormat, t.StartTime)
+	if err != nil {
+		return nil, fmt.Errorf("template %s: %v", t.ID, err)
+	}
+	dayEnd, err := time.Parse(clockFormat, t.EndTime)
+	if err != nil {
+		return nil, fmt.Errorf("template %s: %v", t.ID, err)
+	}
+	if t.SlotMinutes <= 0 {
+		return nil, fmt.Errorf("template %s: slot length must be positive", t.ID)
+	}
+
+	weekdays := map[time.Weekday]bool{}
+	for _, weekday := range t.Weekdays {
+		weekdays[weekday] = true
+	}
+	slotLength := time.Duration(t.SlotMinutes) * time.Minute
+	step := slotLength + time.Duration(t.BreakMinutes)*time.Minute
+
+	var slots []Slot
+	localFrom := from.In(location)
+	day := time.Date(localFrom.Year(), localFrom.Month(), localFrom.Day(), 0, 0, 0, 0, location)
+	for ; day.Before(to); day = day.AddDate(0, 0, 1) {
+		date := day.Format(dateFormat)
+		if !weekdays[day.Weekday()] || closedDates[date] || date < t.EffectiveFrom {
+			continue
+		}
+		if t.EffectiveUntil != nil && date > *t.EffectiveUntil {
+			break
+		}
+
+		// time.Date resolves daylight saving changes
+		end := time.Date(day.Year(), day.Month(), day.Day(), dayEnd.Hour(), dayEnd.Minute(), 0, 0, location)
+		for start := time.Date(day.Year(), day.Month(), day.Day(), dayStart.Hour(), dayStart.Minute(), 0, 0, location); !start.Add(slotLength).After(end); start = start.Add(step) {
+			if start.Before(from) || start.Add(slotLength).After(to) {
+				continue
+			}
+			slots = append(slots, Slot{TemplateID: t.ID, Start: start.UTC(), End: start.Add(slotLength).UTC()})
+		}
+	}
+	return slots, nil
+}
@@ -0,0 +1,189 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func date(t *testing.T, location string, value string) time.Time {
+	t.Helper()
+	zone, err := time.LoadLocation(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := time.ParseInLocation("2006-01-02 15:04", value, zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+func TestTemplateSlots(t *testing.T) {
+	until := "2026-11-03"
+	berlin := Template{
+		ID:            "t1",
+		Weekdays:      []time.Weekday{time.Monday},
+		StartTime:     "09:00",
+		EndTime:       "10:00",
+		SlotMinutes:   25,
+		BreakMinutes:  5,
+		TimeZone:      "Europe/Berlin",
+		EffectiveFrom: "2026-10-19",
+	}
+
+	tests := []struct {
+		name     string
+		template Template
+		from, to time.Time
+		closed   map[string]bool
+		want     []string // slot starts in UTC
+	}{
+		{
+			name:     "slots with breaks, summer time",
+			template: berlin,
+			from:     date(t, "UTC", "2026-10-19 00:00"),
+			to:       date(t, "UTC", "2026-10-20 00:00"),
+			want:     []string{"2026-10-19 07:00", "2026-10-19 07:30"},
+		},
+		{
+			name:     "winter time after the daylight saving change",
+			template: berlin,
+			from:     date(t, "UTC", "2026-10-26 00:00"),
+			to:       date(t, "UTC", "2026-10-27 00:00"),
+			want:     []string{"2026-10-26 08:00", "2026-10-26 08:30"},
+		},
+		{
+			name:     "day off",
+			template: berlin,
+			from:     date(t, "UTC", "2026-10-26 00:00"),
+			to:       date(t, "UTC", "2026-10-27 00:00"),
+			closed:   map[string]bool{"2026-10-26": true},
+			want:     nil,
+		},
+		{
+			name:     "before the effective date",
+			template: berlin,
+			from:     date(t, "UTC", "2026-10-12 00:00"),
+			to:       date(t, "UTC", "2026-10-13 00:00"),
+			want:     nil,
+		},
+		{
+			name: "after the effective end",
+			template: func() Template {
+				template := berlin
+				template.EffectiveUntil = &until
+				return template
+			}(),
+			from: date(t, "UTC", "2026-11-02 00:00"),
+			to:   date(t, "UTC", "2026-11-17 00:00"),
+			want: []string{"2026-11-02 08:00", "2026-11-02 08:30"},
+		},
+		{
+			name:     "slots already started are left out",
+			template: berlin,
+			from:     date(t, "UTC", "2026-10-19 07:10"),
+			to:       date(t, "UTC", "2026-10-20 00:00"),
+			want:     []string{"2026-10-19 07:30"},
+		},
+		{
+			name:     "slots must end before to",
+			template: berlin,
+			from:     date(t, "UTC", "2026-10-19 00:00"),
+			to:       date(t, "UTC", "2026-10-19 07:40"),
+			want:     []string{"2026-10-19 07:00"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			slots, err := test.template.Slots(test.from, test.to, test.closed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, slot := range slots {
+				if slot.End.Sub(slot.Start) != 25*time.Minute || slot.TemplateID != "t1" {
+					t.Errorf("unexpected slot %+v", slot)
+				}
+				got = append(got, slot.Start.UTC().Format("2006-01-02 15:04"))
+			}
+			if len(got) != len(test.want) {
+				t.Fatalf("got %v, want %v", got, test.want)
+			}
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Fatalf("got %v, want %v", got, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestTemplateSlotsInvalid(t *testing.T) {
+	for _, template := range []Template{
+		{ID: "zone", TimeZone: "Nowhere/City", StartTime: "09:00", EndTime: "10:00", SlotMinutes: 30},
+		{ID: "clock", TimeZone: "UTC", StartTime: "9h", EndTime: "10:00", SlotMinutes: 30},
+		{ID: "length", TimeZone: "UTC", StartTime: "09:00", EndTime: "10:00", SlotMinutes: 0},
+	} {
+		if _, err := template.Slots(time.Now(), time.Now().Add(Horizon), nil); err == nil {
+			t.Errorf("template %s: expected an error", template.ID)
+		}
+	}
+}
+
+func TestDiffSlots(t *testing.T) {
+	at := func(value string) time.Time { return date(t, "UTC", value) }
+	slot := func(templateID, start string, minutes int) Slot {
+		return Slot{TemplateID: templateID, Start: at(start), End: at(start).Add(time.Duration(minutes) * time.Minute)}
+	}
+
+	desired := []Slot{
+		slot("a", "2026-11-02 09:00", 30),
+		slot("a", "2026-11-02 09:30", 30),
+		slot("a", "2026-11-02 10:00", 30),
+		// overlaps the 09:30 slot of template a, which was created first
+		slot("b", "2026-11-02 09:45", 30),
+	}
+	existing := []GeneratedSlot{
+		{AvailabilityID: 1, Slot: slot("a", "2026-11-02 09:00", 30)},
+		// the template now has 30 minute slots
+		{AvailabilityID: 2, Slot: slot("a", "2026-11-02 09:30", 20)},
+		// no longer produced
+		{AvailabilityID: 3, Slot: slot("a", "2026-11-02 11:00", 30)},
+		// duplicate of a kept slot
+		{AvailabilityID: 4, Slot: slot("a", "2026-11-02 09:00", 30)},
+	}
+	skipped := map[Occurrence]bool{
+		// deleted by the doctor
+		occurrenceOf(slot("a", "2026-11-02 10:00", 30)): true,
+	}
+
+	missing, stale := diffSlots(desired, existing, skipped)
+
+	if len(missing) != 1 || missing[0] != slot("a", "2026-11-02 09:30", 30) {
+		t.Errorf("missing = %+v, want the 30 minute 09:30 slot only", missing)
+	}
+	wantStale := map[int]bool{2: true, 3: true, 4: true}
+	if len(stale) != len(wantStale) {
+		t.Fatalf("stale = %v, want 2, 3 and 4", stale)
+	}
+	for _, id := range stale {
+		if !wantStale[id] {
+			t.Errorf("stale = %v, want 2, 3 and 4", stale)
+		}
+	}
+}
+
+func TestDiffSlotsSkippedOccurrenceFreesOverlap(t *testing.T) {
+	start := date(t, "UTC", "2026-11-02 09:00")
+	desired := []Slot{
+		{TemplateID: "a", Start: start, End: start.Add(30 * time.Minute)},
+		{TemplateID: "b", Start: start.Add(15 * time.Minute), End: start.Add(45 * time.Minute)},
+	}
+	skipped := map[Occurrence]bool{occurrenceOf(desired[0]): true}
+
+	missing, stale := diffSlots(desired, nil, skipped)
+	if len(stale) != 0 || len(missing) != 1 || missing[0].TemplateID != "b" {
+		t.Errorf("missing = %+v, stale = %v, want only the slot of template b", missing, stale)
+	}
+}
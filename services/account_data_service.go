@@ -0,0 +1,297 @@
+package services
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"tbibi_back_end_go/apierrors"
+	"tbibi_back_end_go/auth"
+	"tbibi_back_end_go/models"
+	"tbibi_back_end_go/validators"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Folder of the export ZIP holding the user's uploads
+const exportFilesDir = "files"
+
+// exportSections are the parts of the manifest, each query returns one JSON document for $1, the user ID.
+// Password hashes, TOTP secrets and token hashes are left out.
+var exportSections = []struct {
+	name  string
+	query string
+}{
+	{"account", `SELECT jsonb_build_object('user_id', user_id, 'email', email, 'user_type', user_type, 'is_verified', is_verified,
+		'preferred_language', preferred_language, 'totp_enabled', totp_enabled, 'created_at', created_at, 'updated_at', updated_at)::text
+		FROM users WHERE user_id = $1`},
+	{"patient_profile", `SELECT COALESCE((SELECT (to_jsonb(p) - 'hashed_password' - 'salt')::text FROM patient_info p WHERE patient_id = $1), 'null')`},
+	{"doctor_profile", `SELECT COALESCE((SELECT (to_jsonb(d) - 'hashed_password' - 'salt')::text FROM doctor_info d WHERE doctor_id = $1), 'null')`},
+	{"appointments", `SELECT COALESCE(jsonb_agg(to_jsonb(a) ORDER BY a.appointment_start), '[]')::text
+		FROM appointments a WHERE a.patient_id = $1 OR a.doctor_id = $1`},
+	{"availabilities", `SELECT COALESCE(jsonb_agg(to_jsonb(a) ORDER BY a.availability_start), '[]')::text
+		FROM availabilities a WHERE a.doctor_id = $1`},
+	{"chats", `SELECT COALESCE(jsonb_agg(jsonb_build_object(
+			'chat_id', p.chat_id,
+			'joined_at', p.joined_at,
+			'participants', (SELECT jsonb_agg(o.user_id) FROM participants o WHERE o.chat_id = p.chat_id),
+			'messages', (SELECT COALESCE(jsonb_agg(jsonb_build_object('id', m.id, 'sender_id', m.sender_id, 'content', m.content, 'created_at', m.created_at) ORDER BY m.created_at), '[]')
+				FROM messages m WHERE m.chat_id = p.chat_id AND m.deleted_at IS NULL)
+		)), '[]')::text
+		FROM participants p WHERE p.user_id = $1`},
+	{"files", `SELECT COALESCE(jsonb_agg(jsonb_build_object('id', f.id, 'name', f.name, 'type', f.type, 'extension', f.extension, 'size', f.size,
+			'parent_id', f.parent_id, 'created_at', f.created_at, 'updated_at', f.updated_at,
+			'path_in_export', regexp_replace(f.path, '^(\./)?uploads/[^/]+', '` + exportFilesDir + `'))
+		ORDER BY f.created_at), '[]')::text
+		FROM folder_file_info f WHERE f.user_id = $1`},
+	{"shared_items", `SELECT COALESCE(jsonb_agg(to_jsonb(s) ORDER BY s.shared_at), '[]')::text
+		FROM shared_items s WHERE s.shared_by_id = $1::text OR s.shared_with_id = $1::text`},
+	{"sessions", `SELECT COALESCE(jsonb_agg(jsonb_build_object('session_id', session_id, 'user_agent', user_agent, 'ip_address', ip_address,
+			'created_at', created_at, 'last_used_at', last_used_at, 'expires_at', expires_at, 'revoked_at', revoked_at) ORDER BY created_at), '[]')::text
+		FROM refresh_tokens WHERE user_id = $1`},
+	{"login_attempts", `SELECT COALESCE(jsonb_agg(jsonb_build_object('ip_address', ip_address, 'succeeded', succeeded, 'failure_reason', failure_reason,
+			'user_agent', user_agent, 'attempted_at', attempted_at) ORDER BY attempted_at), '[]')::text
+		FROM login_attempts WHERE user_id = $1`},
+}
+
+// ExportAccountData sends the authenticated user a ZIP with all their data: a
+// manifest.json with their account, profile, appointments, chats and file
+// records, and the files they uploaded.
+func ExportAccountData(c *gin.Context, pool *pgxpool.Pool) {
+	ctx := context.Background()
+	userID := auth.GetUserID(c)
+
+	// The manifest is built first so that a database error can still be answered properly
+	manifest := map[string]interface{}{
+		"exported_at":     time.Now().UTC(),
+		"user_id":         userID,
+		"files_directory": exportFilesDir,
+	}
+	for _, section := range exportSections {
+		var document string
+		if err := pool.QueryRow(ctx, section.query, userID).Scan(&document); err != nil {
+			apierrors.Internal(c, "Error exporting "+section.name, err)
+			return
+		}
+		manifest[section.name] = json.RawMessage(document)
+	}
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		apierrors.Internal(c, "Error encoding export manifest", err)
+		return
+	}
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", `attachment; filename="tbibi-export-`+time.Now().Format("2006-01-02")+`.zip"`)
+	c.Status(http.StatusOK)
+
+	zipWriter := zip.NewWriter(c.Writer)
+	defer zipWriter.Close()
+
+	f, err := zipWriter.Create("manifest.json")
+	if err == nil {
+		_, err = f.Write(manifestJSON)
+	}
+	if err != nil {
+		log.Printf("[%s] Error writing export manifest: %v", apierrors.GetRequestID(c), err)
+		c.Abort()
+		return
+	}
+
+	uploadsPath := filepath.Join("./uploads", userID)
+	if _, err := os.Stat(uploadsPath); err == nil {
+		if err := addFilesToZip(zipWriter, uploadsPath, exportFilesDir); err != nil {
+			log.Printf("[%s] Error adding files to export: %v", apierrors.GetRequestID(c), err)
+			c.Abort()
+			return
+		}
+	}
+}
+
+// DeleteAccount closes the authenticated user's account. Data only the user
+// owns is deleted, records other users keep (past appointments, chats) are
+// detached from the account, upcoming appointments are cancelled and the
+// uploads directory is removed.
+func DeleteAccount(c *gin.Context, pool *pgxpool.Pool) {
+	var req struct {
+		Password string `json:"password" binding:"required"`
+		Code     string `json:"code" binding:"omitempty,max=32"`
+	}
+	if !validators.BindJSON(c, &req) {
+		return
+	}
+
+	account, state, ok := currentAccount(c, pool)
+	if !ok {
+		return
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(account.HashedPassword), []byte(req.Password)); err != nil {
+		apierrors.Abort(c, apierrors.CodeInvalidCredentials, "Invalid password")
+		return
+	}
+	if state.Enabled && !verifySecondFactor(c, pool, account, state.Secret, req.Code, true) {
+		return
+	}
+
+	ctx := context.Background()
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		apierrors.Internal(c, "Transaction Error", err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	if err := deleteAccountData(ctx, tx, account); err != nil {
+		apierrors.Internal(c, "Error deleting account", err)
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		apierrors.Internal(c, "Commit Error", err)
+		return
+	}
+
+	if err := os.RemoveAll(filepath.Join("./uploads", account.UserID)); err != nil {
+		log.Printf("[%s] Error removing uploads of deleted account %s: %v", apierrors.GetRequestID(c), account.UserID, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Your account has been deleted"})
+}
+
+// accountClosedReason is the cancellation reason of the appointments of a deleted account
+const accountClosedReason = "The account was closed"
+
+type cancelledAppointment struct {
+	doctorID, patientID, title string
+	start, end                 time.Time
+}
+
+func deleteAccountData(ctx context.Context, tx pgx.Tx, account *Account) error {
+	userID := account.UserID
+
+	// The schedules of the doctors involved are locked before their appointments, like in every other change to them
+	doctors := map[string]bool{}
+	if account.UserType == auth.RoleDoctor {
+		if _, err := lockDoctorSchedule(ctx, tx, userID); err != nil && err != pgx.ErrNoRows {
+			return err
+		}
+		doctors[userID] = true
+	} else {
+		rows, err := tx.Query(ctx, `
+			SELECT DISTINCT doctor_id::text FROM appointments
+			WHERE patient_id = $1 AND doctor_id IS NOT NULL AND appointment_start > NOW() AND status = $2
+			ORDER BY 1`, userID, models.AppointmentBooked)
+		if err != nil {
+			return err
+		}
+		var doctorIDs []string
+		for rows.Next() {
+			var doctorID string
+			if err := rows.Scan(&doctorID); err != nil {
+				rows.Close()
+				return err
+			}
+			doctorIDs = append(doctorIDs, doctorID)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, doctorID := range doctorIDs {
+			if _, err := lockDoctorSchedule(ctx, tx, doctorID); err != nil {
+				return err
+			}
+			doctors[doctorID] = true
+		}
+	}
+
+	// Upcoming appointments are cancelled, the other party is told and a patient's slots are offered again
+	rows, err := tx.Query(ctx, `
+		UPDATE appointments SET status = $2, cancelled_at = NOW(), cancelled_by = $1, cancellation_reason = $3
+		WHERE (patient_id = $1 OR doctor_id = $1) AND appointment_start > NOW() AND status = $4
+		RETURNING COALESCE(doctor_id::text, ''), COALESCE(patient_id::text, ''), title, appointment_start, appointment_end`,
+		userID, models.AppointmentCancelled, accountClosedReason, models.AppointmentBooked)
+	if err != nil {
+		return err
+	}
+	var cancelled []cancelledAppointment
+	for rows.Next() {
+		var appointment cancelledAppointment
+		if err := rows.Scan(&appointment.doctorID, &appointment.patientID, &appointment.title, &appointment.start, &appointment.end); err != nil {
+			rows.Close()
+			return err
+		}
+		cancelled = append(cancelled, appointment)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, appointment := range cancelled {
+		err := notifyAppointmentCancelled(ctx, tx, appointment.doctorID, appointment.patientID, userID,
+			appointment.title, accountClosedReason, appointment.start, appointment.end)
+		if err != nil {
+			return err
+		}
+		if account.UserType == auth.RolePatient && appointment.doctorID != "" {
+			// booked after the schedules were locked above
+			if !doctors[appointment.doctorID] {
+				if _, err := lockDoctorSchedule(ctx, tx, appointment.doctorID); err != nil {
+					return err
+				}
+				doctors[appointment.doctorID] = true
+			}
+			if _, err := restoreSlot(ctx, tx, appointment.doctorID, appointment.start, appointment.end); err != nil {
+				return err
+			}
+		}
+	}
+
+	statements := []string{
+		// past appointments stay in the other party's history without the account
+		"UPDATE appointments SET patient_id = NULL WHERE patient_id = $1",
+		"UPDATE appointments SET doctor_id = NULL WHERE doctor_id = $1",
+		"UPDATE appointments SET cancelled_by = NULL WHERE cancelled_by = $1",
+		"DELETE FROM availabilities WHERE doctor_id = $1",
+
+		"DELETE FROM shared_items WHERE item_id IN (SELECT id FROM folder_file_info WHERE user_id = $1)",
+		"DELETE FROM shared_items WHERE shared_by_id = $1::text OR shared_with_id = $1::text",
+		"DELETE FROM folder_file_info WHERE user_id = $1",
+
+		"DELETE FROM messages WHERE sender_id = $1",
+		"DELETE FROM participants WHERE user_id = $1",
+		"DELETE FROM messages WHERE chat_id IN (SELECT id FROM chats WHERE NOT EXISTS (SELECT 1 FROM participants WHERE participants.chat_id = chats.id))",
+		"DELETE FROM chats WHERE NOT EXISTS (SELECT 1 FROM participants WHERE participants.chat_id = chats.id)",
+
+		"DELETE FROM refresh_tokens WHERE user_id = $1",
+		"DELETE FROM login_attempts WHERE user_id = $1",
+		"DELETE FROM patient_info WHERE patient_id = $1",
+		"DELETE FROM doctor_info WHERE doctor_id = $1",
+		// recovery codes and password reset tokens are removed with the user
+		"DELETE FROM users WHERE user_id = $1",
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(ctx, statement, userID); err != nil {
+			return err
+		}
+	}
+
+	// rows keyed by email address
+	for _, statement := range []string{
+		"DELETE FROM login_attempts WHERE LOWER(email) = LOWER($1)",
+		"DELETE FROM verification_tokens WHERE LOWER(email) = LOWER($1)",
+		"DELETE FROM email_outbox WHERE LOWER(recipient) = LOWER($1)",
+	} {
+		if _, err := tx.Exec(ctx, statement, account.Email); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"tbibi_back_end_go/apierrors"
+	"tbibi_back_end_go/auth"
+	"tbibi_back_end_go/emails"
+	"tbibi_back_end_go/models"
+	"tbibi_back_end_go/validators"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Account is a row of the users table shared by patients, doctors and admins
+type Account struct {
+	UserID         string
+	Email          string
+	HashedPassword string
+	UserType          string
+	IsVerified        bool
+	PreferredLanguage string
+}
+
+// findAccountByEmail looks up an account by email regardless of its type, it returns pgx.ErrNoRows when there is none
+func findAccountByEmail(ctx context.Context, db auth.Querier, email string) (*Account, error) {
+	var account Account
+	err := db.QueryRow(ctx,
+		"SELECT user_id, email, hashed_password, user_type, is_verified, preferred_language FROM users WHERE LOWER(email) = LOWER($1)",
+		email).Scan(&account.UserID, &account.Email, &account.HashedPassword, &account.UserType, &account.IsVerified, &account.PreferredLanguage)
+	if err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+// findAccountByID looks up an account by its user ID
+func findAccountByID(ctx context.Context, db auth.Querier, userID string) (*Account, error) {
+	var account Account
+	err := db.QueryRow(ctx,
+		"SELECT user_id, email, hashed_password, user_type, is_verified, preferred_language FROM users WHERE user_id::text = $1",
+		userID).Scan(&account.UserID, &account.Email, &account.HashedPassword, &account.UserType, &account.IsVerified, &account.PreferredLanguage)
+	if err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+// accountEmailExists reports whether any account already uses the email
+func accountEmailExists(ctx context.Context, db auth.Querier, email string) (bool, error) {
+	_, err := findAccountByEmail(ctx, db, email)
+	if err == pgx.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// insertAccount creates the users row for a new account and returns its ID, which is also used as the profile ID
+func insertAccount(ctx context.Context, tx pgx.Tx, email string, hashedPassword []byte, userType string, language string) (string, error) {
+	var userID string
+	err := tx.QueryRow(ctx, `
+		INSERT INTO users (email, hashed_password, user_type, is_verified, preferred_language, created_at, updated_at)
+		VALUES ($1, $2, $3, FALSE, $4, NOW(), NOW())
+		RETURNING user_id`,
+		email, string(hashedPassword), userType, emails.SupportedLanguage(language)).Scan(&userID)
+	return userID, err
+}
+
+// rejectWeakPassword answers with the list of policy violations when the password
+// is not acceptable, and reports whether it did
+func rejectWeakPassword(c *gin.Context, password string, personalInfo ...string) bool {
+	violations := validators.CheckPassword(password, personalInfo...)
+	if violations == nil {
+		return false
+	}
+	apierrors.Abort(c, apierrors.CodeWeakPassword, "Password does not meet the requirements", gin.H{"violations": violations})
+	return true
+}
+
+// profileIDForAccount returns the patient_id, doctor_id or admin_id of the profile linked to the account
+func profileIDForAccount(ctx context.Context, db auth.Querier, account *Account) (string, error) {
+	var query string
+	switch account.UserType {
+	case auth.RolePatient:
+		query = "SELECT patient_id FROM patient_info WHERE user_id = $1"
+	case auth.RoleDoctor:
+		query = "SELECT doctor_id FROM doctor_info WHERE user_id = $1"
+	case auth.RoleAdmin:
+		query = "SELECT admin_id FROM admin_info WHERE user_id = $1"
+	default:
+		return "", fmt.Errorf("unknown user type %q", account.UserType)
+	}
+	var profileID string
+	err := db.QueryRow(ctx, query, account.UserID).Scan(&profileID)
+	return profileID, err
+}
+
+// Login authenticates any account type and tells the client which role it has
+func Login(c *gin.Context, pool *pgxpool.Pool) {
+	loginAccount(c, pool, "")
+}
+
+// loginAccount runs the login flow shared by every login endpoint. When
+// userType is set only accounts of that type may log in.
+func loginAccount(c *gin.Context, pool *pgxpool.Pool, userType string) {
+	var loginReq models.LoginRequest
+
+	if !validators.BindJSON(c, &loginReq) {
+		return
+	}
+
+	// Refusing the attempt while the email or the client IP is locked out
+	remaining, err := loginLockout(c, pool, loginReq.Email)
+	if err != nil {
+		apierrors.Internal(c, "Error checking login lockout", err)
+		return
+	}
+	if remaining > 0 {
+		recordLoginAttempt(c, pool, loginReq.Email, "", loginFailureLocked)
+		respondLoginLocked(c, remaining)
+		return
+	}
+
+	// Fetching the account from the database based on the email
+	ctx := context.Background()
+	account, err := findAccountByEmail(ctx, pool, loginReq.Email)
+	if err != nil || (userType != "" && account.UserType != userType) {
+		if err != nil && err != pgx.ErrNoRows {
+			log.Println("Error fetching account:", err)
+		}
+		// Spending the same time as a real password check so unknown emails can not be told apart
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(loginReq.Password))
+		recordLoginAttempt(c, pool, loginReq.Email, "", loginFailureUnknownAccount)
+		apierrors.Abort(c, apierrors.CodeInvalidCredentials, "Invalid email or password")
+		return
+	}
+
+	// Comparing the stored hashed password, with the hashed version of the password that was received
+	err = bcrypt.CompareHashAndPassword([]byte(account.HashedPassword), []byte(loginReq.Password))
+	if err != nil {
+		recordLoginAttempt(c, pool, loginReq.Email, account.UserID, loginFailureWrongPassword)
+		apierrors.Abort(c, apierrors.CodeInvalidCredentials, "Invalid email or password")
+		return
+	}
+
+	// checking if the account is verified, only once the password is known to be right
+	if !account.IsVerified {
+		recordLoginAttempt(c, pool, loginReq.Email, account.UserID, loginFailureNotVerified)
+		apierrors.Abort(c, apierrors.CodeAccountNotVerified, "Account Not Verified, Please check your email to verify your account.")
+		return
+	}
+
+	// Accounts with 2FA, or that were told to set it up, only get an mfa token here
+	twoFactor, err := loadTwoFactorState(ctx, pool, account.UserID)
+	if err != nil {
+		apierrors.Internal(c, "Error fetching 2FA state", err)
+		return
+	}
+	if twoFactor.Enabled || twoFactor.Required {
+		respondMFARequired(c, account, twoFactor)
+		return
+	}
+
+	completeLogin(c, pool, account, nil)
+}
+
+// completeLogin opens a session for an account that passed every login step and
+// sends the tokens, together with any extra fields the caller wants to return
+func completeLogin(c *gin.Context, pool *pgxpool.Pool, account *Account, extra gin.H) {
+	ctx := context.Background()
+
+	profileID, err := profileIDForAccount(ctx, pool, account)
+	if err != nil {
+		apierrors.Internal(c, "Error fetching profile", err)
+		return
+	}
+
+	// generating a session token
+	user := auth.User{ID: account.UserID, Type: account.UserType, Verified: account.IsVerified}
+	token, refreshToken, err := createSession(c, pool, user)
+	if err != nil {
+		apierrors.Internal(c, "Error creating session", err)
+		return
+	}
+	recordLoginAttempt(c, pool, account.Email, account.UserID, "")
+
+	response := gin.H{
+		"success":                true,
+		"token":                  token,
+		"refresh_token":          refreshToken,
+		"role":                   account.UserType,
+		"user_id":                account.UserID,
+		"profile_id":             profileID,
+		account.UserType + "_id": profileID,
+	}
+	for key, value := range extra {
+		response[key] = value
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// UpdatePreferredLanguage changes the language the user's emails are sent in
+func UpdatePreferredLanguage(c *gin.Context, pool *pgxpool.Pool) {
+	var requestBody struct {
+		PreferredLanguage string `json:"preferred_language" binding:"required,language"`
+	}
+	if !validators.BindJSON(c, &requestBody) {
+		return
+	}
+	language := requestBody.PreferredLanguage
+
+	_, err := pool.Exec(context.Background(),
+		"UPDATE users SET preferred_language = $1, updated_at = NOW() WHERE user_id = $2", language, auth.GetUserID(c))
+	if err != nil {
+		apierrors.Internal(c, "Error updating preferred language", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "preferred_language": language})
+}
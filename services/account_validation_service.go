@@ -3,82 +3,68 @@ package services
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
-	"fmt"
 	"log"
 	"net/http"
-	"net/smtp"
-	"os"
+	"strings"
+	"tbibi_back_end_go/apierrors"
+	"tbibi_back_end_go/auth"
+	"tbibi_back_end_go/emails"
+	"tbibi_back_end_go/outbox"
+	"tbibi_back_end_go/validators"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
-	"github.com/joho/godotenv"
 	"golang.org/x/crypto/bcrypt"
 )
 
 
+// ActivateAccount verifies the account the activation link was sent for
 func ActivateAccount(c *gin.Context, pool *pgxpool.Pool) {
     token := c.Query("token")
     if token == "" {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid token"})
+        apierrors.Abort(c, apierrors.CodeInvalidToken, "Invalid token")
         return
 
     }
 
     var email string
-    err := pool.QueryRow(context.Background(), "SELECT email FROM verification_tokens WHERE token = $1 AND type = $2", token, "Account Validation").Scan(&email)
-    if err != nil {		
-		log.Println("Token is no longer valid")
-		c.JSON(http.StatusOK, gin.H{"success": false, "message": "Token is no longer valid"})
-    }
-        
-    var patientId string
-    err = pool.QueryRow(context.Background(), "SELECT patient_id FROM patient_info WHERE email = $1", email).Scan(&patientId)
-    if err != nil {
-        log.Println("Error retrieving patient ID:", err)
-        if err.Error() == "no rows in result set" {
-            log.Println("User is not a patient.")
-        } else {
-            c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
-            return
+    var expiresAt time.Time
+    err := pool.QueryRow(context.Background(), "SELECT email, expires_at FROM verification_tokens WHERE token = $1 AND type = $2", token, validators.TokenTypeAccountValidation).Scan(&email, &expiresAt)
+    if err == pgx.ErrNoRows {
+        apierrors.Abort(c, apierrors.CodeInvalidToken, "Token is no longer valid")
+        return
+    }
+    if err != nil {
+        apierrors.Internal(c, "Error reading verification token", err)
+        return
+    }
+
+    if time.Now().After(expiresAt) {
+        _, err = pool.Exec(context.Background(), "DELETE FROM verification_tokens WHERE token = $1", token)
+        if err != nil {
+            log.Printf("Failed to delete verification token: %v", err)
         }
-    } else {
-        log.Println("Got the patient id:", patientId)
+        apierrors.Abort(c, apierrors.CodeTokenExpired, "This link has expired, please request a new verification email", gin.H{"expired": true})
+        return
     }
 
-    var doctorId string
-    err = pool.QueryRow(context.Background(), "SELECT doctor_id FROM doctor_info WHERE email = $1", email).Scan(&doctorId)
+    tag, err := pool.Exec(context.Background(), "UPDATE users SET is_verified = true, updated_at = NOW() WHERE LOWER(email) = LOWER($1)", email)
     if err != nil {
-        log.Println("Error retrieving doctor ID:", err)
-        if err.Error() == "no rows in result set" {
-            log.Println("User is not a doctor.")
-        } else {
-            c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
-            return
-        }
-    } else {
-        log.Println("Got the doctor id:", doctorId)
-    }
-
-	if patientId != "" {
-		_, err = pool.Exec(context.Background(), "UPDATE patient_info SET is_verified = true WHERE email = $1", email)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
-			return
-		}
-	} else if doctorId != "" {
-		_, err = pool.Exec(context.Background(), "UPDATE doctor_info SET is_verified = true WHERE email = $1", email)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
-			return
-		}
-	} else {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
-		return
-	}
+        apierrors.Internal(c, "Error verifying account", err)
+        return
+    }
+    if tag.RowsAffected() == 0 {
+        log.Println("No account found for email:", email)
+        apierrors.Abort(c, apierrors.CodeInternal, "Internal server error")
+        return
+    }
 
 
-    _, err = pool.Exec(context.Background(), "DELETE FROM verification_tokens WHERE token = $1", token)
+    _, err = pool.Exec(context.Background(), "DELETE FROM verification_tokens WHERE LOWER(email) = LOWER($1) AND type = $2", email, validators.TokenTypeAccountValidation)
     if err != nil {
         log.Printf("Failed to delete verification token: %v", err)
     }
@@ -86,9 +72,73 @@ func ActivateAccount(c *gin.Context, pool *pgxpool.Pool) {
     c.JSON(http.StatusOK, gin.H{"success": true, "message": "Account activated successfully"})
 }
 
+// ResendVerification sends a new activation link to an unverified account. The
+// response is the same whether or not the email belongs to an account, and
+// whether or not an email was just sent to it.
+func ResendVerification(c *gin.Context, pool *pgxpool.Pool) {
+    var requestBody struct {
+        Email string `json:"email" binding:"required,email"`
+    }
+    if !validators.BindJSON(c, &requestBody) {
+        return
+    }
+    response := gin.H{"message": "If this email belongs to an account that is not verified yet, a new verification link has been sent."}
+
+    ctx := context.Background()
+    account, err := findAccountByEmail(ctx, pool, strings.TrimSpace(requestBody.Email))
+    if err == pgx.ErrNoRows || (err == nil && account.IsVerified) {
+        c.JSON(http.StatusOK, response)
+        return
+    }
+    if err != nil {
+        apierrors.Internal(c, "Error fetching account", err)
+        return
+    }
+
+    recent, err := validators.VerificationRecentlySent(ctx, pool, account.Email)
+    if err != nil {
+        apierrors.Internal(c, "Error checking verification tokens", err)
+        return
+    }
+    // throttled silently, a different answer would tell which emails have accounts
+    if recent {
+        c.JSON(http.StatusOK, response)
+        return
+    }
+
+    tx, err := pool.Begin(ctx)
+    if err != nil {
+        apierrors.Internal(c, "Transaction Error", err)
+        return
+    }
+    defer tx.Rollback(ctx)
+
+    verificationLink := validators.GenerateVerificationLink(account.Email, tx)
+    if verificationLink == "" {
+        apierrors.Abort(c, apierrors.CodeInternal, "Could not generate verification link")
+        return
+    }
+    if err := validators.QueueVerificationEmail(ctx, tx, account.Email, account.PreferredLanguage, verificationLink); err != nil {
+        apierrors.Internal(c, "Failed to queue verification email", err)
+        return
+    }
+    if err := tx.Commit(ctx); err != nil {
+        apierrors.Internal(c, "Commit Error", err)
+        return
+    }
+
+    c.JSON(http.StatusOK, response)
+}
+
 
 const TokenLength = 64 
 
+// passwordResetTokenTTL is how long a password reset link stays valid
+const passwordResetTokenTTL = time.Hour
+
+// passwordResetInterval is the minimum time between two reset emails to the same account
+const passwordResetInterval = time.Minute
+
 //  Gnerate a secure random hex string
 func GenerateSecureToken() (string, error) {
     bytes := make([]byte, TokenLength/2)
@@ -99,143 +149,182 @@ func GenerateSecureToken() (string, error) {
 }
 
 
-// Send reset password email
-func SendResetPasswordEmail(recipientEmail, verificationLink string) error {
-
-	err := godotenv.Load("./.env")
-    if err != nil {
-        log.Fatal("Error loading .env file")
-    }
-	
-	from := os.Getenv("SMTP_EMAIL")
-	password := os.Getenv("SMTP_EMAIL_PASSWORD")
-	to := []string{recipientEmail}
-	smtpHost := os.Getenv("SMTP_HOST")
-	smtpPort := os.Getenv("SMTP_PORT")
-	subject := "Reset your TBIBI app password."
-	body := "Please click on the on the link below to reset your password:\n" + verificationLink
-
-	message := []byte("From: " + from + "\n" +
-		"To: " + recipientEmail + "\n" +
-		"Subject: " + subject + "\n\n" +
-		body)
+// hashResetToken is what is stored of a reset token, the token itself only exists in the email
+func hashResetToken(token string) string {
+    sum := sha256.Sum256([]byte(token))
+    return hex.EncodeToString(sum[:])
+}
 
-	auth := smtp.PlainAuth("", from, password, smtpHost)
 
-	err = smtp.SendMail(smtpHost+":"+smtpPort, auth, from, to, message)
+// Queue reset password email
+func QueueResetPasswordEmail(ctx context.Context, db auth.Querier, recipientEmail, language, verificationLink string) error {
+	msg, err := emails.Build(recipientEmail, language, emails.PasswordReset, emails.PasswordResetData{
+		Link:             verificationLink,
+		ExpiresInMinutes: int(passwordResetTokenTTL / time.Minute),
+	})
 	if err != nil {
-		return fmt.Errorf("failed to send reset password email: %v", err)
+		return err
 	}
-	return nil
+	return outbox.Enqueue(ctx, db, msg)
 }
 
 
 
-// RequestReset handles the initiation of the password reset process
+// RequestReset handles the initiation of the password reset process. The
+// response is the same whether or not the email belongs to an account, and
+// whether or not an email was just sent to it.
 func RequestReset(c *gin.Context, pool *pgxpool.Pool) {
     var requestBody struct{
-        Email string `json:"email"`
-        UserType string `json:"localUserType"`
+        Email string `json:"email" binding:"required,email"`
     }
-    if err := c.BindJSON(&requestBody); err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+    if !validators.BindJSON(c, &requestBody) {
         return
     }
+    response := gin.H{"message": "If your email is in our system, you will receive a password reset link shortly."}
 
-    var table string
-    if requestBody.UserType == "doctor" {
-        table = "doctor_info"
-    } else {
-        table = "patient_info"
+    // One reset flow for every account type
+    ctx := context.Background()
+    account, err := findAccountByEmail(ctx, pool, strings.TrimSpace(requestBody.Email))
+    if err == pgx.ErrNoRows {
+        c.JSON(http.StatusOK, response)
+        return
+    }
+    if err != nil {
+        apierrors.Internal(c, "Error fetching account", err)
+        return
     }
 
-    var email string
-    query := fmt.Sprintf("SELECT email FROM %s WHERE email = $1", table)
-    err := pool.QueryRow(c, query, requestBody.Email).Scan(&email)
-
+    // Asking again right away does not send another email
+    var recent bool
+    err = pool.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM password_reset_tokens WHERE user_id = $1 AND used_at IS NULL AND created_at > $2)",
+        account.UserID, time.Now().Add(-passwordResetInterval)).Scan(&recent)
     if err != nil {
-        c.JSON(http.StatusNotFound, gin.H{"error": "Email not found"})
+        apierrors.Internal(c, "Error checking reset tokens", err)
+        return
+    }
+    if recent {
+        c.JSON(http.StatusOK, response)
         return
     }
 
     token, err := GenerateSecureToken()
     if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate a secure token"})
+        apierrors.Abort(c, apierrors.CodeInternal, "Could not generate a secure token")
         return
     }
 
-    _, err = pool.Exec(c, "INSERT INTO verification_tokens (token, email, type) VALUES ($1, $2, $3)", token, email, "Password Reset")
+    tx, err := pool.Begin(ctx)
+    if err != nil {
+        apierrors.Internal(c, "Transaction Error", err)
+        return
+    }
+    defer tx.Rollback(ctx)
+
+    // Only the latest link works, the ones sent before are dropped
+    _, err = tx.Exec(ctx, "DELETE FROM password_reset_tokens WHERE user_id = $1 AND used_at IS NULL", account.UserID)
+    if err != nil {
+        apierrors.Internal(c, "Error invalidating reset tokens", err)
+        return
+    }
+
+    _, err = tx.Exec(ctx, `
+        INSERT INTO password_reset_tokens (token_hash, user_id, requested_ip, created_at, expires_at)
+        VALUES ($1, $2, $3, NOW(), $4)`,
+        hashResetToken(token), account.UserID, c.ClientIP(), time.Now().Add(passwordResetTokenTTL))
+    if err != nil {
+        apierrors.Internal(c, "Error storing reset token", err)
+        return
+    }
 
-    resetLink := "https://localhost:3000/reset-password?token=" + token
+    resetLink := validators.FrontendURL("/reset-password?token=" + token)
 
-    err = SendResetPasswordEmail(email, resetLink)  
+    err = QueueResetPasswordEmail(ctx, tx, account.Email, account.PreferredLanguage, resetLink)
     if err != nil { 
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send reset password email"})   
+        apierrors.Internal(c, "Failed to queue reset password email", err)
         return
     }   
+    if err := tx.Commit(ctx); err != nil {
+        apierrors.Internal(c, "Commit Error", err)
+        return
+    }
 
-    c.JSON(http.StatusOK, gin.H{"message": "If your email is in our system, you will receive a password reset link shortly."})
+    c.JSON(http.StatusOK, response)
 }
 
 
+// UpdatePassword sets a new password with a reset token. The token can only be
+// used once and every session of the account is ended.
 func UpdatePassword(c *gin.Context, pool *pgxpool.Pool) {
     var requestBody struct {
-        Token       string
-        NewPassword string
+        Token       string `binding:"required"`
+        NewPassword string `binding:"required"`
     }
-    if err := c.BindJSON(&requestBody); err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+    if !validators.BindJSON(c, &requestBody) {
         return
     }
 
-    query := fmt.Sprintf("SELECT email FROM verification_tokens WHERE token = $1 AND type = $2")
-    var email string
-    err := pool.QueryRow(c, query, requestBody.Token, "Password Reset").Scan(&email)
-    if err != nil { 
-        c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})    
+    ctx := context.Background()
+    tx, err := pool.Begin(ctx)
+    if err != nil {
+        apierrors.Internal(c, "Transaction Error", err)
         return
-    }   
+    }
+    defer tx.Rollback(ctx)
+
+    var userID, email, username string
+    var expiresAt time.Time
+    var usedAt *time.Time
+    err = tx.QueryRow(ctx, `
+        SELECT t.user_id, t.expires_at, t.used_at, u.email, COALESCE(p.username, d.username, '')
+        FROM password_reset_tokens t
+        JOIN users u ON u.user_id = t.user_id
+        LEFT JOIN patient_info p ON p.user_id = u.user_id
+        LEFT JOIN doctor_info d ON d.user_id = u.user_id
+        WHERE t.token_hash = $1
+        FOR UPDATE OF t`,
+        hashResetToken(requestBody.Token)).Scan(&userID, &expiresAt, &usedAt, &email, &username)
+    if err != nil && err != pgx.ErrNoRows {
+        apierrors.Internal(c, "Error fetching reset token", err)
+        return
+    }
+    if err == pgx.ErrNoRows || usedAt != nil || time.Now().After(expiresAt) {
+        apierrors.Abort(c, apierrors.CodeInvalidToken, "Invalid or expired token")
+        return
+    }
+
+    if rejectWeakPassword(c, requestBody.NewPassword, email, username) {
+        return
+    }
 
     hashedPassword, err := bcrypt.GenerateFromPassword([]byte(requestBody.NewPassword), bcrypt.DefaultCost)
     if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+        apierrors.Abort(c, apierrors.CodeInternal, "Internal server error")
         return
     }
 
-    var table string
-    var id string   
-    query = fmt.Sprintf("SELECT patient_id FROM patient_info WHERE email = $1")
-    err = pool.QueryRow(c, query, email).Scan(&id)  
-    if err != nil {     
-        if err.Error() == "no rows in result set" { 
-            query = fmt.Sprintf("SELECT doctor_id FROM doctor_info WHERE email = $1")   
-            err = pool.QueryRow(c, query, email).Scan(&id)  
-            if err != nil {
-                c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
-
-                return  
-            }       
-            table = "doctor_info"
-        } else {
-            c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
-            return
-        }
-    } else {
-        table = "patient_info"
+    _, err = tx.Exec(ctx, "UPDATE users SET hashed_password = $1, updated_at = NOW() WHERE user_id = $2", string(hashedPassword), userID)
+    if err != nil {
+        apierrors.Internal(c, "Error updating password", err)
+        return
     }
 
-    query = fmt.Sprintf("UPDATE %s SET hashed_password = $1 WHERE email = $2", table)
-    _, err = pool.Exec(c, query, hashedPassword, email)
+    _, err = tx.Exec(ctx, "UPDATE password_reset_tokens SET used_at = NOW() WHERE token_hash = $1", hashResetToken(requestBody.Token))
     if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"}) 
+        apierrors.Internal(c, "Error consuming reset token", err)
         return
     }
 
-    _, err = pool.Exec(c, "DELETE FROM verification_tokens WHERE token = $1", requestBody.Token)
+    // Whoever knew the old password is logged out everywhere
+    _, err = tx.Exec(ctx, "UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL", userID)
     if err != nil {
-        log.Printf("Failed to delete verification token: %v", err)
+        apierrors.Internal(c, "Error revoking sessions", err)
+        return
     }
 
+    if err := tx.Commit(ctx); err != nil {
+        apierrors.Internal(c, "Commit Error", err)
+        return
+    }
 
     c.JSON(http.StatusOK, gin.H{"success": true, "message": "Password has been reset successfully."})
-}
\ No newline at end of file
+}
@@ -0,0 +1,252 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strings"
+	"tbibi_back_end_go/apierrors"
+	"tbibi_back_end_go/auth"
+	"tbibi_back_end_go/emails"
+	"tbibi_back_end_go/models"
+	"tbibi_back_end_go/outbox"
+	"tbibi_back_end_go/validators"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// EnsureBootstrapAdmin creates the admin account from ADMIN_EMAIL and ADMIN_PASSWORD if it does not exist yet
+func EnsureBootstrapAdmin(pool *pgxpool.Pool) error {
+	email := strings.TrimSpace(os.Getenv("ADMIN_EMAIL"))
+	password := os.Getenv("ADMIN_PASSWORD")
+	if email == "" || password == "" {
+		return nil
+	}
+
+	ctx := context.Background()
+	exists, err := accountEmailExists(ctx, pool, email)
+	if err != nil || exists {
+		return err
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	adminId, err := insertAccount(ctx, tx, email, hashedPassword, auth.RoleAdmin, emails.DefaultLanguage)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, "UPDATE users SET is_verified = TRUE WHERE user_id = $1", adminId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `
+		INSERT INTO admin_info (admin_id, user_id, first_name, last_name, email)
+		VALUES ($1, $1, 'Admin', 'Admin', $2)`, adminId, email)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// LoginAdmin is the admin counterpart of LoginPatient and LoginDoctor
+func LoginAdmin(c *gin.Context, pool *pgxpool.Pool) {
+	loginAccount(c, pool, auth.RoleAdmin)
+}
+
+const doctorLicenseReviewColumns = `
+	d.doctor_id, d.first_name, d.last_name, d.email, d.phone_number, d.specialty, d.experience, d.medical_license,
+	d.location, COALESCE(u.is_verified, FALSE), d.license_status, d.license_rejection_reason, d.license_reviewed_at, d.create_at
+	FROM doctor_info d LEFT JOIN users u ON u.user_id = d.user_id`
+
+func scanDoctorLicenseReview(row pgx.Row, review *models.DoctorLicenseReview) error {
+	return row.Scan(
+		&review.DoctorID,
+		&review.FirstName,
+		&review.LastName,
+		&review.Email,
+		&review.PhoneNumber,
+		&review.Specialty,
+		&review.Experience,
+		&review.MedicalLicense,
+		&review.Location,
+		&review.EmailVerified,
+		&review.LicenseStatus,
+		&review.RejectionReason,
+		&review.ReviewedAt,
+		&review.RegisteredAt,
+	)
+}
+
+// ListPendingDoctors returns the doctors whose license has not been reviewed yet, oldest first
+func ListPendingDoctors(c *gin.Context, pool *pgxpool.Pool) {
+	rows, err := pool.Query(context.Background(),
+		"SELECT "+doctorLicenseReviewColumns+" WHERE d.license_status = $1 ORDER BY d.create_at ASC",
+		models.LicenseStatusPending)
+	if err != nil {
+		apierrors.Internal(c, "Query Error", err)
+		return
+	}
+	defer rows.Close()
+
+	doctors := []models.DoctorLicenseReview{}
+	for rows.Next() {
+		var review models.DoctorLicenseReview
+		if err := scanDoctorLicenseReview(rows, &review); err != nil {
+			apierrors.Internal(c, "Row Scan Error", err)
+			return
+		}
+		doctors = append(doctors, review)
+	}
+
+	c.JSON(http.StatusOK, doctors)
+}
+
+// GetDoctorLicense returns the license data of a single doctor
+func GetDoctorLicense(c *gin.Context, pool *pgxpool.Pool) {
+	var review models.DoctorLicenseReview
+	row := pool.QueryRow(context.Background(),
+		"SELECT "+doctorLicenseReviewColumns+" WHERE d.doctor_id::text = $1", c.Param("doctorId"))
+	if err := scanDoctorLicenseReview(row, &review); err != nil {
+		if err == pgx.ErrNoRows {
+			apierrors.Abort(c, apierrors.CodeNotFound, "Doctor not found")
+			return
+		}
+		apierrors.Internal(c, "Database error", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, review)
+}
+
+// ApproveDoctor marks a doctor's license as verified, which makes them visible to patients
+func ApproveDoctor(c *gin.Context, pool *pgxpool.Pool) {
+	reviewDoctorLicense(c, pool, models.LicenseStatusApproved, nil)
+}
+
+// RejectDoctor rejects a doctor's license with a reason
+func RejectDoctor(c *gin.Context, pool *pgxpool.Pool) {
+	var requestBody struct {
+		Reason string `json:"reason" binding:"required,notblank,max=500"`
+	}
+	if !validators.BindJSON(c, &requestBody) {
+		return
+	}
+	reason := strings.TrimSpace(requestBody.Reason)
+	reviewDoctorLicense(c, pool, models.LicenseStatusRejected, &reason)
+}
+
+func reviewDoctorLicense(c *gin.Context, pool *pgxpool.Pool, status string, reason *string) {
+	tag, err := pool.Exec(context.Background(), `
+		UPDATE doctor_info
+		SET license_status = $1, license_rejection_reason = $2, license_reviewed_at = NOW(), license_reviewed_by = $3, update_at = NOW()
+		WHERE doctor_id::text = $4`,
+		status, reason, auth.GetUserID(c), c.Param("doctorId"))
+	if err != nil {
+		apierrors.Internal(c, "Error updating license status", err)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		apierrors.Abort(c, apierrors.CodeNotFound, "Doctor not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "license_status": status})
+}
+
+// PreviewEmail renders an email with sample data so admins can check the templates.
+// The language comes from the lang query parameter, format=html returns the HTML
+// page itself and format=text the plain text version.
+func PreviewEmail(c *gin.Context) {
+	name := c.Param("template")
+	data, ok := emails.SampleData(name)
+	if !ok {
+		apierrors.Abort(c, apierrors.CodeNotFound, "Unknown email template", gin.H{"templates": emails.Names})
+		return
+	}
+
+	lang := emails.SupportedLanguage(c.DefaultQuery("lang", emails.DefaultLanguage))
+	msg, err := emails.Render(name, lang, data)
+	if err != nil {
+		apierrors.Internal(c, "Error rendering email", err)
+		return
+	}
+
+	switch c.Query("format") {
+	case "html":
+		c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(msg.HTMLBody))
+	case "text":
+		c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(msg.TextBody))
+	default:
+		c.JSON(http.StatusOK, gin.H{"template": name, "lang": lang, "subject": msg.Subject, "text": msg.TextBody, "html": msg.HTMLBody})
+	}
+}
+
+type OutboxEmail struct {
+	ID            int64      `json:"id"`
+	Recipient     string     `json:"recipient"`
+	Subject       string     `json:"subject"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	LastError     *string    `json:"last_error"`
+	NextAttemptAt time.Time  `json:"next_attempt_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	SentAt        *time.Time `json:"sent_at"`
+	FailedAt      *time.Time `json:"failed_at"`
+}
+
+// ListOutboxEmails shows the queued emails, by default the ones that could not be delivered
+func ListOutboxEmails(c *gin.Context, pool *pgxpool.Pool) {
+	status := c.DefaultQuery("status", outbox.StatusFailed)
+	rows, err := pool.Query(context.Background(), `
+		SELECT id, recipient, subject, status, attempts, last_error, next_attempt_at, created_at, sent_at, failed_at
+		FROM email_outbox WHERE status = $1 ORDER BY created_at DESC LIMIT 200`, status)
+	if err != nil {
+		apierrors.Internal(c, "Query Error", err)
+		return
+	}
+	defer rows.Close()
+
+	queued := []OutboxEmail{}
+	for rows.Next() {
+		var email OutboxEmail
+		if err := rows.Scan(&email.ID, &email.Recipient, &email.Subject, &email.Status, &email.Attempts, &email.LastError,
+			&email.NextAttemptAt, &email.CreatedAt, &email.SentAt, &email.FailedAt); err != nil {
+			apierrors.Internal(c, "Row Scan Error", err)
+			return
+		}
+		queued = append(queued, email)
+	}
+
+	c.JSON(http.StatusOK, queued)
+}
+
+// RetryOutboxEmail puts an email that could not be delivered back in the queue
+func RetryOutboxEmail(c *gin.Context, pool *pgxpool.Pool) {
+	tag, err := pool.Exec(context.Background(), `
+		UPDATE email_outbox SET status = $1, attempts = 0, next_attempt_at = NOW(), failed_at = NULL
+		WHERE id::text = $2 AND status = $3`,
+		outbox.StatusPending, c.Param("emailId"), outbox.StatusFailed)
+	if err != nil {
+		apierrors.Internal(c, "Error requeueing email", err)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		apierrors.Abort(c, apierrors.CodeNotFound, "No failed email with this ID")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
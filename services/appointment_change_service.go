@@ -0,0 +1,266 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strconv"
+	"tbibi_back_end_go/apierrors"
+	"tbibi_back_end_go/auth"
+	"tbibi_back_end_go/models"
+	"tbibi_back_end_go/validators"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Patients cannot cancel or move an appointment later than this before it
+// starts, APPOINTMENT_CANCELLATION_HOURS overrides it (0 for no limit). Doctors
+// can always cancel, the patient is told by email.
+var cancellationWindow = cancellationWindowFromEnv()
+
+func cancellationWindowFromEnv() time.Duration {
+	hours, err := strconv.Atoi(os.Getenv("APPOINTMENT_CANCELLATION_HOURS"))
+	if err != nil || hours < 0 {
+		return 24 * time.Hour
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+type lockedAppointment struct {
+	id, doctorID, patientID, title, status string
+	practiceZone                           string
+	start, end                             time.Time
+}
+
+// lockAppointment locks an appointment of the authenticated user for the
+// transaction, after the doctor's schedule. It answers the request itself when it fails.
+func lockAppointment(c *gin.Context, ctx context.Context, tx pgx.Tx) (*lockedAppointment, bool) {
+	appointment := lockedAppointment{id: c.Param("appointmentId")}
+	userID := auth.GetUserID(c)
+
+	err := tx.QueryRow(ctx, "SELECT doctor_id::text FROM appointments WHERE appointment_id::text = $1 AND (doctor_id::text = $2 OR patient_id::text = $2)",
+		appointment.id, userID).Scan(&appointment.doctorID)
+	if err == pgx.ErrNoRows {
+		apierrors.Abort(c, apierrors.CodeNotFound, "Appointment not found")
+		return nil, false
+	}
+	if err != nil {
+		apierrors.Internal(c, "Error fetching appointment", err)
+		return nil, false
+	}
+	if appointment.practiceZone, err = lockDoctorSchedule(ctx, tx, appointment.doctorID); err != nil {
+		apierrors.Internal(c, "Error locking doctor schedule", err)
+		return nil, false
+	}
+
+	err = tx.QueryRow(ctx, `
+		SELECT COALESCE(patient_id::text, ''), title, status, appointment_start, appointment_end
+		FROM appointments WHERE appointment_id::text = $1 FOR UPDATE`,
+		appointment.id).Scan(&appointment.patientID, &appointment.title, &appointment.status, &appointment.start, &appointment.end)
+	if err != nil {
+		apierrors.Internal(c, "Error locking appointment", err)
+		return nil, false
+	}
+	return &appointment, true
+}
+
+// checkChangeable refuses changes to appointments that are not booked, have
+// started, or whose cancellation window has closed for a patient
+func checkChangeable(c *gin.Context, appointment *lockedAppointment) bool {
+	if appointment.status != models.AppointmentBooked {
+		apierrors.Abort(c, apierrors.CodeConflict, "The appointment is "+appointment.status)
+		return false
+	}
+	if !appointment.start.After(time.Now()) {
+		apierrors.Abort(c, apierrors.CodeConflict, "The appointment has already started")
+		return false
+	}
+	if auth.GetUserType(c) == auth.RolePatient && time.Until(appointment.start) < cancellationWindow {
+		apierrors.Abort(c, apierrors.CodeCancellationClosed, "Appointments cannot be changed this close to their start, please contact the practice",
+			gin.H{"cancellation_window_hours": int(cancellationWindow.Hours())})
+		return false
+	}
+	return true
+}
+
+// restoreSlot offers the time of a cancelled or moved appointment again, unless
+// the doctor has already filled it. The doctor's schedule must be locked.
+func restoreSlot(ctx context.Context, tx pgx.Tx, doctorID string, start, end time.Time) (bool, error) {
+	conflicts, err := slotConflicts(ctx, tx, doctorID, []time.Time{start}, []time.Time{end}, 0)
+	if err != nil || len(conflicts) > 0 {
+		return false, err
+	}
+	_, err = tx.Exec(ctx, "INSERT INTO availabilities (availability_start, availability_end, doctor_id) VALUES ($1, $2, $3)", start, end, doctorID)
+	return err == nil, err
+}
+
+// CancelAppointment cancels one of the authenticated user's upcoming appointments.
+// The time is offered to other patients again, the doctor can delete the slot
+// if they are not available.
+func CancelAppointment(c *gin.Context, pool *pgxpool.Pool) {
+	var request models.AppointmentCancellation
+	if !validators.BindJSON(c, &request) {
+		return
+	}
+
+	ctx := context.Background()
+	userID := auth.GetUserID(c)
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		apierrors.Internal(c, "Transaction Error", err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	appointment, ok := lockAppointment(c, ctx, tx)
+	if !ok || !checkChangeable(c, appointment) {
+		return
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE appointments SET status = $1, cancelled_at = NOW(), cancelled_by = $2, cancellation_reason = NULLIF($3, '')
+		WHERE appointment_id::text = $4`,
+		models.AppointmentCancelled, userID, request.Reason, appointment.id)
+	if err != nil {
+		apierrors.Internal(c, "Error cancelling appointment", err)
+		return
+	}
+
+	slotRestored, err := restoreSlot(ctx, tx, appointment.doctorID, appointment.start, appointment.end)
+	if err != nil {
+		apierrors.Internal(c, "Error restoring availability", err)
+		return
+	}
+
+	err = notifyAppointmentCancelled(ctx, tx, appointment.doctorID, appointment.patientID, userID,
+		appointment.title, request.Reason, appointment.start, appointment.end)
+	if err != nil {
+		apierrors.Internal(c, "Error queueing cancellation email", err)
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		apierrors.Internal(c, "Commit Error", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "status": models.AppointmentCancelled, "slot_restored": slotRestored})
+}
+
+// RescheduleAppointment moves one of the authenticated user's upcoming
+// appointments to another free slot of the same doctor. The old time is offered
+// to other patients again.
+func RescheduleAppointment(c *gin.Context, pool *pgxpool.Pool) {
+	var request models.AppointmentReschedule
+	if !validators.BindJSON(c, &request) {
+		return
+	}
+	requested, ok := requestedLocation(c)
+	if !ok {
+		return
+	}
+
+	ctx := context.Background()
+	userID := auth.GetUserID(c)
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		apierrors.Internal(c, "Transaction Error", err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	appointment, ok := lockAppointment(c, ctx, tx)
+	if !ok || !checkChangeable(c, appointment) {
+		return
+	}
+
+	var start, end time.Time
+	err = tx.QueryRow(ctx,
+		"SELECT availability_start, availability_end FROM availabilities WHERE availability_id = $1 AND doctor_id = $2 FOR UPDATE",
+		request.AvailabilityID, appointment.doctorID).Scan(&start, &end)
+	if err == pgx.ErrNoRows {
+		apierrors.Abort(c, apierrors.CodeSlotConflict, "This slot is no longer available")
+		return
+	}
+	if err != nil {
+		apierrors.Internal(c, "Error locking availability", err)
+		return
+	}
+	if !start.After(time.Now()) {
+		apierrors.Abort(c, apierrors.CodeSlotConflict, "This slot has already started")
+		return
+	}
+
+	_, err = tx.Exec(ctx, "UPDATE appointments SET appointment_start = $1, appointment_end = $2 WHERE appointment_id::text = $3",
+		start, end, appointment.id)
+	if err != nil {
+		apierrors.Internal(c, "Error rescheduling appointment", err)
+		return
+	}
+	_, err = tx.Exec(ctx, "DELETE FROM availabilities WHERE availability_id = $1", request.AvailabilityID)
+	if err != nil {
+		apierrors.Internal(c, "Error removing availability", err)
+		return
+	}
+
+	slotRestored, err := restoreSlot(ctx, tx, appointment.doctorID, appointment.start, appointment.end)
+	if err != nil {
+		apierrors.Internal(c, "Error restoring availability", err)
+		return
+	}
+
+	err = notifyAppointmentRescheduled(ctx, tx, appointment.doctorID, appointment.patientID, userID,
+		appointment.title, request.Reason, appointment.start, start, end)
+	if err != nil {
+		apierrors.Internal(c, "Error queueing reschedule email", err)
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		apierrors.Internal(c, "Commit Error", err)
+		return
+	}
+	zones := newZoneResolver(requested)
+	c.JSON(http.StatusOK, gin.H{
+		"success":          true,
+		"appointment_id":   appointment.id,
+		"AppointmentStart": zones.in(start, appointment.practiceZone),
+		"AppointmentEnd":   zones.in(end, appointment.practiceZone),
+		"slot_restored":    slotRestored,
+	})
+}
+
+// SetAppointmentOutcome lets the doctor mark an appointment that has started as completed or as a no-show
+func SetAppointmentOutcome(c *gin.Context, pool *pgxpool.Pool) {
+	var request models.AppointmentOutcome
+	if !validators.BindJSON(c, &request) {
+		return
+	}
+
+	var status string
+	err := pool.QueryRow(context.Background(), `
+		UPDATE appointments SET status = CASE WHEN status = $1 AND appointment_start <= NOW() THEN $2 ELSE status END
+		WHERE appointment_id::text = $3 AND doctor_id::text = $4
+		RETURNING status`,
+		models.AppointmentBooked, request.Status, c.Param("appointmentId"), auth.GetUserID(c)).Scan(&status)
+	if err == pgx.ErrNoRows {
+		apierrors.Abort(c, apierrors.CodeNotFound, "Appointment not found")
+		return
+	}
+	if err != nil {
+		apierrors.Internal(c, "Error updating appointment", err)
+		return
+	}
+	if status != request.Status {
+		if status == models.AppointmentBooked {
+			apierrors.Abort(c, apierrors.CodeConflict, "The appointment has not started yet")
+		} else {
+			apierrors.Abort(c, apierrors.CodeConflict, "The appointment is "+status)
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "status": status})
+}
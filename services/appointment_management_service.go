@@ -2,140 +2,220 @@ package services
 
 import (
 	"context"
-	"log"
 	"net/http"
+	"tbibi_back_end_go/apierrors"
+	"tbibi_back_end_go/auth"
 	"tbibi_back_end_go/models"
+	"tbibi_back_end_go/validators"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 )
 
 
 
+// GetAvailabilities lists the free slots of a doctor on a day of their calendar.
+// The day starts at midnight in the practice time zone, the slots are returned
+// in the zone asked for with timeZone, by default in the practice zone.
 func GetAvailabilities(c *gin.Context, pool *pgxpool.Pool) {
-    doctorId := c.DefaultQuery("doctorId", "")
-    day := c.DefaultQuery("day", "")
-    currentTime := c.DefaultQuery("currentTime", "")
-    timeZone := c.DefaultQuery("timeZone", "")
+	doctorId := c.DefaultQuery("doctorId", "")
+	day := c.DefaultQuery("day", "")
+	currentTime := c.DefaultQuery("currentTime", "")
 
-	const customDateFormat = "2006-01-02" 
-	dayStart, err := time.Parse(customDateFormat, day) 
+	requested, ok := requestedLocation(c)
+	if !ok {
+		return
+	}
+
+	var practiceZone string
+	err := pool.QueryRow(context.Background(), "SELECT time_zone FROM doctor_info WHERE doctor_id::text = $1", doctorId).Scan(&practiceZone)
+	if err == pgx.ErrNoRows {
+		apierrors.Abort(c, apierrors.CodeNotFound, "Doctor not found")
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid day format"})
+		apierrors.Internal(c, "Error fetching doctor time zone", err)
 		return
 	}
+	zones := newZoneResolver(requested)
+	practice := newZoneResolver(nil).location(practiceZone)
 
-    dayEnd := dayStart.AddDate(0, 0, 1)
-    location, err := time.LoadLocation(timeZone)
-    if err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid time zone"})
-        return
-    }
+	const customDateFormat = "2006-01-02"
+	dayStart, err := time.ParseInLocation(customDateFormat, day, practice)
+	if err != nil {
+		apierrors.Abort(c, apierrors.CodeInvalidRequest, "Invalid day format")
+		return
+	}
+	// AddDate keeps midnight on days with a daylight saving change
+	dayEnd := dayStart.AddDate(0, 0, 1)
 
-    localCurrentTime, err := time.ParseInLocation(time.RFC3339, currentTime, location)
-    if err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid current time format"})
-        return
-    }
+	// slots that have started are left out, currentTime carries its own offset
+	notBefore := time.Now()
+	if currentTime != "" {
+		notBefore, err = time.Parse(time.RFC3339, currentTime)
+		if err != nil {
+			apierrors.Abort(c, apierrors.CodeInvalidRequest, "Invalid current time format")
+			return
+		}
+	}
 
-    rows, err := pool.Query(context.Background(),
-        "SELECT availability_id, availability_start, availability_end, doctor_id FROM availabilities WHERE doctor_id = $1 AND availability_start >= $2 AND availability_end < $3 AND availability_start >= $4",
-        doctorId, dayStart, dayEnd, localCurrentTime)
+	rows, err := pool.Query(context.Background(), `
+		SELECT availability_id, availability_start, availability_end, doctor_id FROM availabilities
+		WHERE doctor_id::text = $1 AND availability_start >= $2 AND availability_start < $3 AND availability_start >= $4
+		ORDER BY availability_start`,
+		doctorId, dayStart, dayEnd, notBefore)
 	if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-        return
-    }
-    defer rows.Close()
-
-    var availabilities []models.Availability 
-    berlinLocation, _ := time.LoadLocation("Europe/Berlin")
-    for rows.Next() {
-        var availability models.Availability
-        err := rows.Scan(&availability.AvailabilityID, &availability.AvailabilityStart, &availability.AvailabilityEnd, &availability.DoctorID)
-        if err != nil {
-            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-            return
-        }
-
-        availability.AvailabilityStart = availability.AvailabilityStart.In(berlinLocation)
-        availability.AvailabilityEnd = availability.AvailabilityEnd.In(berlinLocation)
-        availabilities = append(availabilities, availability)
-    }
-
-    c.JSON(http.StatusOK, availabilities)
+		apierrors.Internal(c, "Error querying availabilities", err)
+		return
+	}
+	defer rows.Close()
+
+	availabilities := []models.Availability{}
+	for rows.Next() {
+		var availability models.Availability
+		err := rows.Scan(&availability.AvailabilityID, &availability.AvailabilityStart, &availability.AvailabilityEnd, &availability.DoctorID)
+		if err != nil {
+			apierrors.Internal(c, "Error querying availabilities", err)
+			return
+		}
+
+		availability.AvailabilityStart = zones.in(availability.AvailabilityStart, practiceZone)
+		availability.AvailabilityEnd = zones.in(availability.AvailabilityEnd, practiceZone)
+		availabilities = append(availabilities, availability)
+	}
+
+	c.JSON(http.StatusOK, availabilities)
 }
 
+// Appointments is the booking request. The times are taken from the slot, when
+// the client sends them they have to match it.
 type Appointments struct {
 	AppointmentStart          time.Time `json:"AppointmentStart"`
-	AppointmentEnd            time.Time `json:"AppointmentEnd"`
-	AppointmentTitle          string    `json:"AppointmentTitle"`
-	DoctorID       string    `json:"DoctorID"`
+	AppointmentEnd            time.Time `json:"AppointmentEnd" binding:"omitempty,gtfield=AppointmentStart"`
+	AppointmentTitle          string    `json:"AppointmentTitle" binding:"required,notblank,max=50"`
+	DoctorID       string    `json:"DoctorID" binding:"required,uuid"`
 	PatientID      string    `json:"PatientID"`
-	AvailabilityID int       `json:"AvailabilityID"`
+	AvailabilityID int       `json:"AvailabilityID" binding:"required,gt=0"`
 }
 
 // Implement POST /api/v1/reservations
+// The slot is locked for the length of the transaction: of two patients booking
+// it at once, the second waits and then finds it gone.
 func CreateReservation(c *gin.Context, pool *pgxpool.Pool) {
 	var appointment Appointments
 
-	if err := c.ShouldBindJSON(&appointment); err != nil {
-		log.Println("Bind Error:", err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+	if !validators.BindJSON(c, &appointment) {
 		return
 	}
 
-	conn, err := pool.Acquire(context.Background())
+	// Patients always book for themselves
+	appointment.PatientID = auth.GetUserID(c)
+
+	requested, ok := requestedLocation(c)
+	if !ok {
+		return
+	}
+
+	ctx := context.Background()
+	tx, err := pool.Begin(ctx)
 	if err != nil {
-		log.Println("Connection Error:", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+		apierrors.Internal(c, "Transaction Error", err)
 		return
 	}
-	defer conn.Release()
+	defer tx.Rollback(ctx)
 
-	tx, err := conn.Begin(context.Background())
+	// Every change to the doctor's slots and appointments takes this lock first
+	practiceZone, err := lockDoctorSchedule(ctx, tx, appointment.DoctorID)
+	if err == pgx.ErrNoRows {
+		apierrors.Abort(c, apierrors.CodeSlotConflict, "This slot is no longer available")
+		return
+	}
 	if err != nil {
-		log.Println("Transaction Error:", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+		apierrors.Internal(c, "Error locking doctor schedule", err)
 		return
 	}
 
-	// Insert reservation
-	_, err = tx.Exec(context.Background(),
-    "INSERT INTO appointments (appointment_start, appointment_end, appointment_title, doctor_id, patient_id) VALUES ($1::timestamp with time zone, $2::timestamp with time zone, $3, $4, $5)",
-    appointment.AppointmentStart, appointment.AppointmentEnd, appointment.AppointmentTitle, appointment.DoctorID, appointment.PatientID)
+	var slotStart, slotEnd time.Time
+	err = tx.QueryRow(ctx,
+		"SELECT availability_start, availability_end FROM availabilities WHERE availability_id = $1 AND doctor_id = $2 FOR UPDATE",
+		appointment.AvailabilityID, appointment.DoctorID).Scan(&slotStart, &slotEnd)
+	if err == pgx.ErrNoRows {
+		apierrors.Abort(c, apierrors.CodeSlotConflict, "This slot is no longer available")
+		return
+	}
+	if err != nil {
+		apierrors.Internal(c, "Error locking availability", err)
+		return
+	}
+	if !slotStart.After(time.Now()) {
+		apierrors.Abort(c, apierrors.CodeSlotConflict, "This slot has already started")
+		return
+	}
+	// a client showing other times than the slot's has a stale view of the schedule
+	if (!appointment.AppointmentStart.IsZero() && !appointment.AppointmentStart.Equal(slotStart)) ||
+		(!appointment.AppointmentEnd.IsZero() && !appointment.AppointmentEnd.Equal(slotEnd)) {
+		apierrors.Abort(c, apierrors.CodeSlotConflict, "This slot has been moved, please reload the schedule")
+		return
+	}
 
+	var appointmentID string
+	err = tx.QueryRow(ctx,
+		"INSERT INTO appointments (appointment_start, appointment_end, title, doctor_id, patient_id) VALUES ($1, $2, $3, $4, $5) RETURNING appointment_id::text",
+		slotStart, slotEnd, appointment.AppointmentTitle, appointment.DoctorID, appointment.PatientID).Scan(&appointmentID)
 	if err != nil {
-		log.Println("Insert Error:", err)
-		tx.Rollback(context.Background())
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+		apierrors.Internal(c, "Error creating appointment", err)
 		return
 	}
 
-	// Delete availability
-	_, err = tx.Exec(context.Background(), "DELETE FROM availabilities WHERE availability_id = $1", appointment.AvailabilityID)
+	// A booked slot is no longer an availability
+	_, err = tx.Exec(ctx, "DELETE FROM availabilities WHERE availability_id = $1", appointment.AvailabilityID)
 	if err != nil {
-		log.Println("Delete Error:", err)
-		tx.Rollback(context.Background())
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+		apierrors.Internal(c, "Error removing availability", err)
 		return
 	}
 
-	tx.Commit(context.Background())
-	c.JSON(http.StatusCreated, gin.H{"message": "Appointment booked and availability removed successfully"})
+	// The confirmation email is queued with the booking
+	err = notifyAppointmentConfirmed(ctx, tx, appointment.DoctorID, appointment.PatientID, appointment.AppointmentTitle, slotStart, slotEnd)
+	if err != nil {
+		apierrors.Internal(c, "Error queueing confirmation email", err)
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		apierrors.Internal(c, "Commit Error", err)
+		return
+	}
+	zones := newZoneResolver(requested)
+	c.JSON(http.StatusCreated, gin.H{
+		"message":          "Appointment booked and availability removed successfully",
+		"appointment_id":   appointmentID,
+		"AppointmentStart": zones.in(slotStart, practiceZone),
+		"AppointmentEnd":   zones.in(slotEnd, practiceZone),
+	})
 }
 
 
 
 // Implement GET /api/v1/reservations
 func GetReservations(c *gin.Context, pool *pgxpool.Pool) {
-	doctorID := c.DefaultQuery("doctor_id", "")
-	patientID := c.DefaultQuery("patient_id", "")
-	timezone := c.DefaultQuery("timezone", "UTC")
+	// Times are shown in the requested zone, by default in the practice zone of each doctor
+	requested, ok := requestedLocation(c)
+	if !ok {
+		return
+	}
+	zones := newZoneResolver(requested)
 
-	if doctorID == "" && patientID == "" {
-		log.Println("Bad Request: doctor_id or patient_id required")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad Request: doctor_id or patient_id required"})
+	// Reservations are always listed for the authenticated user
+	var doctorID, patientID string
+	switch auth.GetUserType(c) {
+	case "doctor":
+		doctorID = auth.GetUserID(c)
+	case "patient":
+		patientID = auth.GetUserID(c)
+	default:
+		auth.AbortForbidden(c)
 		return
 	}
 
@@ -144,25 +224,27 @@ func GetReservations(c *gin.Context, pool *pgxpool.Pool) {
 			appointments.appointment_id,
 			appointments.appointment_start,
 			appointments.appointment_end,
-			doctor_info.first_name,
-			doctor_info.last_name,
-			doctor_info.specialty,
-			patient_info.first_name AS patient_first_name,
-			patient_info.last_name AS patient_last_name,
-			patient_info.age,
-			patient_info.patient_id,
-			doctor_info.doctor_id
+			COALESCE(doctor_info.first_name, ''),
+			COALESCE(doctor_info.last_name, ''),
+			COALESCE(doctor_info.specialty, ''),
+			COALESCE(patient_info.first_name, '') AS patient_first_name,
+			COALESCE(patient_info.last_name, '') AS patient_last_name,
+			COALESCE(patient_info.age, 0),
+			COALESCE(patient_info.patient_id::text, ''),
+			COALESCE(doctor_info.doctor_id::text, ''),
+			appointments.status,
+			appointments.cancellation_reason,
+			COALESCE(doctor_info.time_zone, '` + models.DefaultPracticeTimeZone + `')
 		FROM 
 			appointments
-		JOIN
+		-- the other party is missing once they deleted their account
+		LEFT JOIN
 			doctor_info ON appointments.doctor_id = doctor_info.doctor_id
-		JOIN
+		LEFT JOIN
 			patient_info ON appointments.patient_id = patient_info.patient_id
 	`
 	params := []interface{}{}
 	if doctorID != "" {
-		// log print for the type of the doctorID
-		log.Println(doctorID)
 		query += " WHERE appointments.doctor_id = $1"
 		params = append(params, doctorID)
 	} else {
@@ -170,43 +252,33 @@ func GetReservations(c *gin.Context, pool *pgxpool.Pool) {
 		params = append(params, patientID)
 	}
 
+	query += " ORDER BY appointments.appointment_start"
+
 	rows, err := pool.Query(context.Background(), query, params...)
 	if err != nil {
-		log.Println("Query Error:", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+		apierrors.Internal(c, "Query Error", err)
 		return
 	}
-	// print the retrieved data from the front
-	log.Println(rows)
 	defer rows.Close()
 
 	var reservations []models.Reservation
 	for rows.Next() {
 		var r models.Reservation
+		var practiceZone string
 		err := rows.Scan(&r.ReservationID, &r.ReservationStart, &r.ReservationEnd,
 			&r.DoctorFirstName, &r.DoctorLastName, &r.Specialty,
-			&r.PatientFirstName, &r.PatientLastName, &r.Age, &r.PatientID, &r.DoctorID)
-		if err != nil {
-			log.Println("Row Scan Error:", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
-			return
-		}
-		log.Println("r", r)
-		// Convert time to the specified timezone
-		location, err := time.LoadLocation(timezone)
+			&r.PatientFirstName, &r.PatientLastName, &r.Age, &r.PatientID, &r.DoctorID,
+			&r.Status, &r.CancellationReason, &practiceZone)
 		if err != nil {
-			log.Println("Timezone Error:", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			apierrors.Internal(c, "Row Scan Error", err)
 			return
 		}
-	
-		r.ReservationStart = r.ReservationStart.In(location)
-		r.ReservationEnd = r.ReservationEnd.In(location)
-		log.Println("r", r)		// Append to the reservations slice
+		r.ReservationStart = zones.in(r.ReservationStart, practiceZone)
+		r.ReservationEnd = zones.in(r.ReservationEnd, practiceZone)
+		// Append to the reservations slice
 		reservations = append(reservations, r)
 		
 	}
 	
-	log.Println(reservations)
 	c.JSON(http.StatusOK, reservations)
 }
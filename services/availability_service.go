@@ -0,0 +1,381 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"tbibi_back_end_go/apierrors"
+	"tbibi_back_end_go/auth"
+	"tbibi_back_end_go/models"
+	"tbibi_back_end_go/schedule"
+	"tbibi_back_end_go/validators"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+const (
+	maxSlotDuration = 12 * time.Hour
+	// most slots a single bulk request may create
+	maxBulkSlots = 500
+)
+
+// validateSlot refuses slots in the past and slots longer than maxSlotDuration
+func validateSlot(start, end time.Time) []validators.FieldError {
+	var fields []validators.FieldError
+	if !start.After(time.Now()) {
+		fields = append(fields, validators.FieldError{Field: "AvailabilityStart", Rule: "future", Message: "must be in the future"})
+	}
+	if end.Sub(start) > maxSlotDuration {
+		fields = append(fields, validators.FieldError{Field: "AvailabilityEnd", Rule: "max_duration", Message: "slots cannot be longer than " + maxSlotDuration.String()})
+	}
+	return fields
+}
+
+// lockDoctorSchedule serializes the changes to a doctor's slots, so that an
+// overlap check stays true until the transaction commits. It returns the
+// practice time zone.
+func lockDoctorSchedule(ctx context.Context, tx pgx.Tx, doctorID string) (string, error) {
+	var practiceZone string
+	err := tx.QueryRow(ctx, "SELECT time_zone FROM doctor_info WHERE doctor_id = $1 FOR UPDATE", doctorID).Scan(&practiceZone)
+	return practiceZone, err
+}
+
+// slotConflicts returns the indexes of the slots that overlap another slot or a
+// booked appointment of the doctor. excludeID is a slot being moved, 0 for none.
+func slotConflicts(ctx context.Context, tx pgx.Tx, doctorID string, starts, ends []time.Time, excludeID int) (map[int]bool, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT s.i FROM unnest($2::timestamptz[], $3::timestamptz[]) WITH ORDINALITY AS s(start_at, end_at, i)
+		WHERE EXISTS (SELECT 1 FROM availabilities a WHERE a.doctor_id = $1 AND a.availability_id <> $4
+				AND a.availability_start < s.end_at AND a.availability_end > s.start_at)
+			OR EXISTS (SELECT 1 FROM appointments p WHERE p.doctor_id = $1 AND p.status = 'booked'
+				AND p.appointment_start < s.end_at AND p.appointment_end > s.start_at)`,
+		doctorID, starts, ends, excludeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	conflicts := map[int]bool{}
+	for rows.Next() {
+		var i int
+		if err := rows.Scan(&i); err != nil {
+			return nil, err
+		}
+		conflicts[i-1] = true
+	}
+	return conflicts, rows.Err()
+}
+
+// CreateAvailability adds one slot to the authenticated doctor's availabilities
+func CreateAvailability(c *gin.Context, pool *pgxpool.Pool) {
+	var slot models.AvailabilitySlot
+	if !validators.BindJSON(c, &slot) {
+		return
+	}
+	requested, ok := requestedLocation(c)
+	if !ok {
+		return
+	}
+	if fields := validateSlot(slot.AvailabilityStart, slot.AvailabilityEnd); len(fields) > 0 {
+		apierrors.Abort(c, apierrors.CodeValidationFailed, "Invalid request", gin.H{"fields": fields})
+		return
+	}
+	start, end := slot.AvailabilityStart.UTC(), slot.AvailabilityEnd.UTC()
+
+	ctx := context.Background()
+	doctorID := auth.GetUserID(c)
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		apierrors.Internal(c, "Transaction Error", err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	practiceZone, err := lockDoctorSchedule(ctx, tx, doctorID)
+	if err != nil {
+		apierrors.Internal(c, "Error locking doctor schedule", err)
+		return
+	}
+	conflicts, err := slotConflicts(ctx, tx, doctorID, []time.Time{start}, []time.Time{end}, 0)
+	if err != nil {
+		apierrors.Internal(c, "Error checking slot overlaps", err)
+		return
+	}
+	if len(conflicts) > 0 {
+		apierrors.Abort(c, apierrors.CodeSlotConflict, "The slot overlaps another slot or an appointment")
+		return
+	}
+
+	availability := models.Availability{DoctorID: doctorID}
+	err = tx.QueryRow(ctx, `
+		INSERT INTO availabilities (availability_start, availability_end, doctor_id) VALUES ($1, $2, $3)
+		RETURNING availability_id, availability_start, availability_end`,
+		start, end, doctorID).Scan(&availability.AvailabilityID, &availability.AvailabilityStart, &availability.AvailabilityEnd)
+	if err != nil {
+		apierrors.Internal(c, "Error creating availability", err)
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		apierrors.Internal(c, "Commit Error", err)
+		return
+	}
+
+	zones := newZoneResolver(requested)
+	availability.AvailabilityStart = zones.in(availability.AvailabilityStart, practiceZone)
+	availability.AvailabilityEnd = zones.in(availability.AvailabilityEnd, practiceZone)
+	c.JSON(http.StatusCreated, availability)
+}
+
+// CreateAvailabilities splits a time range into slots for the authenticated doctor.
+// Slots overlapping existing ones are refused, or skipped with SkipConflicts.
+func CreateAvailabilities(c *gin.Context, pool *pgxpool.Pool) {
+	var request models.AvailabilityRange
+	if !validators.BindJSON(c, &request) {
+		return
+	}
+	requested, ok := requestedLocation(c)
+	if !ok {
+		return
+	}
+	if !request.From.After(time.Now()) {
+		apierrors.Abort(c, apierrors.CodeValidationFailed, "Invalid request", gin.H{"fields": []validators.FieldError{
+			{Field: "From", Rule: "future", Message: "must be in the future"},
+		}})
+		return
+	}
+
+	slotLength := time.Duration(request.SlotMinutes) * time.Minute
+	step := slotLength + time.Duration(request.BreakMinutes)*time.Minute
+	var starts, ends []time.Time
+	for start := request.From.UTC(); !start.Add(slotLength).After(request.To.UTC()); start = start.Add(step) {
+		if len(starts) == maxBulkSlots {
+			apierrors.Abort(c, apierrors.CodeValidationFailed, "Invalid request", gin.H{"fields": []validators.FieldError{
+				{Field: "To", Rule: "max_slots", Message: "at most " + strconv.Itoa(maxBulkSlots) + " slots can be created at once"},
+			}})
+			return
+		}
+		starts = append(starts, start)
+		ends = append(ends, start.Add(slotLength))
+	}
+	if len(starts) == 0 {
+		apierrors.Abort(c, apierrors.CodeValidationFailed, "Invalid request", gin.H{"fields": []validators.FieldError{
+			{Field: "To", Rule: "min_slots", Message: "the range is shorter than one slot"},
+		}})
+		return
+	}
+
+	ctx := context.Background()
+	doctorID := auth.GetUserID(c)
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		apierrors.Internal(c, "Transaction Error", err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	practiceZone, err := lockDoctorSchedule(ctx, tx, doctorID)
+	if err != nil {
+		apierrors.Internal(c, "Error locking doctor schedule", err)
+		return
+	}
+	conflicts, err := slotConflicts(ctx, tx, doctorID, starts, ends, 0)
+	if err != nil {
+		apierrors.Internal(c, "Error checking slot overlaps", err)
+		return
+	}
+
+	zones := newZoneResolver(requested)
+	skipped := []models.AvailabilitySlot{}
+	var freeStarts, freeEnds []time.Time
+	for i := range starts {
+		if conflicts[i] {
+			skipped = append(skipped, models.AvailabilitySlot{
+				AvailabilityStart: zones.in(starts[i], practiceZone),
+				AvailabilityEnd:   zones.in(ends[i], practiceZone),
+			})
+			continue
+		}
+		freeStarts = append(freeStarts, starts[i])
+		freeEnds = append(freeEnds, ends[i])
+	}
+	if len(skipped) > 0 && !request.SkipConflicts {
+		apierrors.Abort(c, apierrors.CodeSlotConflict, "Some slots overlap other slots or appointments", gin.H{"conflicts": skipped})
+		return
+	}
+
+	created := []models.Availability{}
+	if len(freeStarts) > 0 {
+		rows, err := tx.Query(ctx, `
+			INSERT INTO availabilities (availability_start, availability_end, doctor_id)
+			SELECT s.start_at, s.end_at, $1 FROM unnest($2::timestamptz[], $3::timestamptz[]) AS s(start_at, end_at)
+			RETURNING availability_id, availability_start, availability_end`,
+			doctorID, freeStarts, freeEnds)
+		if err != nil {
+			apierrors.Internal(c, "Error creating availabilities", err)
+			return
+		}
+		for rows.Next() {
+			availability := models.Availability{DoctorID: doctorID}
+			if err := rows.Scan(&availability.AvailabilityID, &availability.AvailabilityStart, &availability.AvailabilityEnd); err != nil {
+				rows.Close()
+				apierrors.Internal(c, "Error creating availabilities", err)
+				return
+			}
+			availability.AvailabilityStart = zones.in(availability.AvailabilityStart, practiceZone)
+			availability.AvailabilityEnd = zones.in(availability.AvailabilityEnd, practiceZone)
+			created = append(created, availability)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			apierrors.Internal(c, "Error creating availabilities", err)
+			return
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		apierrors.Internal(c, "Commit Error", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"created": created, "skipped": skipped})
+}
+
+// UpdateAvailability moves one of the authenticated doctor's slots
+func UpdateAvailability(c *gin.Context, pool *pgxpool.Pool) {
+	availabilityID, err := strconv.Atoi(c.Param("availabilityId"))
+	if err != nil {
+		apierrors.Abort(c, apierrors.CodeInvalidRequest, "Invalid availability ID")
+		return
+	}
+	var slot models.AvailabilitySlot
+	if !validators.BindJSON(c, &slot) {
+		return
+	}
+	requested, ok := requestedLocation(c)
+	if !ok {
+		return
+	}
+	if fields := validateSlot(slot.AvailabilityStart, slot.AvailabilityEnd); len(fields) > 0 {
+		apierrors.Abort(c, apierrors.CodeValidationFailed, "Invalid request", gin.H{"fields": fields})
+		return
+	}
+	start, end := slot.AvailabilityStart.UTC(), slot.AvailabilityEnd.UTC()
+
+	ctx := context.Background()
+	doctorID := auth.GetUserID(c)
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		apierrors.Internal(c, "Transaction Error", err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	practiceZone, err := lockDoctorSchedule(ctx, tx, doctorID)
+	if err != nil {
+		apierrors.Internal(c, "Error locking doctor schedule", err)
+		return
+	}
+	conflicts, err := slotConflicts(ctx, tx, doctorID, []time.Time{start}, []time.Time{end}, availabilityID)
+	if err != nil {
+		apierrors.Internal(c, "Error checking slot overlaps", err)
+		return
+	}
+	if len(conflicts) > 0 {
+		apierrors.Abort(c, apierrors.CodeSlotConflict, "The slot overlaps another slot or an appointment")
+		return
+	}
+
+	// a moved template slot becomes a manual one, its old occurrence is not generated again
+	if err := skipGeneratedSlot(ctx, tx, doctorID, availabilityID); err != nil {
+		apierrors.Internal(c, "Error detaching slot from its schedule", err)
+		return
+	}
+
+	availability := models.Availability{DoctorID: doctorID}
+	err = tx.QueryRow(ctx, `
+		UPDATE availabilities SET availability_start = $1, availability_end = $2, schedule_template_id = NULL
+		WHERE availability_id = $3 AND doctor_id = $4
+		RETURNING availability_id, availability_start, availability_end`,
+		start, end, availabilityID, doctorID).Scan(&availability.AvailabilityID, &availability.AvailabilityStart, &availability.AvailabilityEnd)
+	if err == pgx.ErrNoRows {
+		apierrors.Abort(c, apierrors.CodeNotFound, "Availability not found")
+		return
+	}
+	if err != nil {
+		apierrors.Internal(c, "Error updating availability", err)
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		apierrors.Internal(c, "Commit Error", err)
+		return
+	}
+
+	zones := newZoneResolver(requested)
+	availability.AvailabilityStart = zones.in(availability.AvailabilityStart, practiceZone)
+	availability.AvailabilityEnd = zones.in(availability.AvailabilityEnd, practiceZone)
+	c.JSON(http.StatusOK, availability)
+}
+
+// skipGeneratedSlot records that a template slot was changed by hand, so that
+// the schedule generator does not produce it again
+func skipGeneratedSlot(ctx context.Context, tx pgx.Tx, doctorID string, availabilityID int) error {
+	var templateID *string
+	var start time.Time
+	err := tx.QueryRow(ctx,
+		"SELECT schedule_template_id::text, availability_start FROM availabilities WHERE availability_id = $1 AND doctor_id = $2",
+		availabilityID, doctorID).Scan(&templateID, &start)
+	if err == pgx.ErrNoRows || (err == nil && templateID == nil) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return schedule.SkipOccurrence(ctx, tx, doctorID, *templateID, start)
+}
+
+// DeleteAvailability removes one of the authenticated doctor's slots. Booked
+// slots are no longer availabilities, so an appointment is never removed here.
+func DeleteAvailability(c *gin.Context, pool *pgxpool.Pool) {
+	availabilityID, err := strconv.Atoi(c.Param("availabilityId"))
+	if err != nil {
+		apierrors.Abort(c, apierrors.CodeInvalidRequest, "Invalid availability ID")
+		return
+	}
+
+	ctx := context.Background()
+	doctorID := auth.GetUserID(c)
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		apierrors.Internal(c, "Transaction Error", err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := lockDoctorSchedule(ctx, tx, doctorID); err != nil {
+		apierrors.Internal(c, "Error locking doctor schedule", err)
+		return
+	}
+	// a deleted template slot stays deleted
+	if err := skipGeneratedSlot(ctx, tx, doctorID, availabilityID); err != nil {
+		apierrors.Internal(c, "Error detaching slot from its schedule", err)
+		return
+	}
+	tag, err := tx.Exec(ctx, "DELETE FROM availabilities WHERE availability_id = $1 AND doctor_id = $2", availabilityID, doctorID)
+	if err != nil {
+		apierrors.Internal(c, "Error deleting availability", err)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		apierrors.Abort(c, apierrors.CodeNotFound, "Availability not found")
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		apierrors.Internal(c, "Commit Error", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
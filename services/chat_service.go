@@ -6,7 +6,10 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"tbibi_back_end_go/apierrors"
+	"tbibi_back_end_go/auth"
 	"tbibi_back_end_go/models"
+	"tbibi_back_end_go/validators"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v4"
@@ -69,11 +72,11 @@ func GetChatsForUser(db *pgx.Conn, userID string) ([]models.Chat, error) {
 }
 
 func ListChatsForUser(db *pgx.Conn, c *gin.Context) {
-	userID := c.Query("userID") 
+	userID := auth.GetUserID(c)
     log.Println("userID: ", userID)
 	chats, err := GetChatsForUser(db, userID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve chats"})
+		apierrors.Abort(c, apierrors.CodeInternal, "Failed to retrieve chats")
 		return
 	}
 	c.JSON(http.StatusOK, chats)
@@ -81,24 +84,33 @@ func ListChatsForUser(db *pgx.Conn, c *gin.Context) {
 
 
 type CombinedMessage struct {
-    ChatID     string       `json:"chat_id"`
+    ChatID     string       `json:"chat_id" binding:"required,uuid"`
     SenderID   string       `json:"sender_id"`
-    RecipientID string `json:"recipient_id"`
-    Content    string    `json:"content"`
+    RecipientID string `json:"recipient_id" binding:"omitempty,uuid"`
+    Content    string    `json:"content" binding:"required,notblank,max=5000"`
 }
 
 // SendMessage - sends a new message to a chat
 func SendMessage(db *pgx.Conn, c *gin.Context) {
     var newMessage CombinedMessage
-    if err := c.BindJSON(&newMessage); err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message format: " + err.Error()})
+    if !validators.BindJSON(c, &newMessage) {
         return
     }
+    newMessage.SenderID = auth.GetUserID(c)
 
-    err := storeMessage(db, newMessage.SenderID, newMessage.ChatID, newMessage.Content)
+    participant, err := auth.IsChatParticipant(context.Background(), db, newMessage.ChatID, newMessage.SenderID)
     if err != nil {
-        log.Printf("Failed to store message: %v", err)
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store message"})
+        apierrors.Internal(c, "Failed to check chat participant", err)
+        return
+    }
+    if !participant {
+        auth.AbortForbidden(c)
+        return
+    }
+
+    err = storeMessage(db, newMessage.SenderID, newMessage.ChatID, newMessage.Content)
+    if err != nil {
+        apierrors.Internal(c, "Failed to store message", err)
         return
     }
 
@@ -117,27 +129,29 @@ func SearchUsers(c *gin.Context, pool *pgxpool.Pool) {
 	
 	var combinedUsers []CombinedUser
 
-    queries := map[string]string{
-        "patient": `SELECT patient_id, first_name, last_name FROM patient_info WHERE LOWER(first_name || ' ' || last_name) LIKE LOWER($1)`,
-        "doctor":  `SELECT doctor_id, first_name, last_name FROM doctor_info WHERE LOWER(first_name || ' ' || last_name) LIKE LOWER($1)`,
+    // Patients and doctors are searched together through their accounts
+    query := `
+    SELECT u.user_id, COALESCE(pa.first_name, da.first_name), COALESCE(pa.last_name, da.last_name)
+    FROM users AS u
+    LEFT JOIN patient_info AS pa ON pa.user_id = u.user_id
+    LEFT JOIN doctor_info AS da ON da.user_id = u.user_id
+    WHERE u.user_type IN ('patient', 'doctor')
+    AND LOWER(COALESCE(pa.first_name, da.first_name) || ' ' || COALESCE(pa.last_name, da.last_name)) LIKE LOWER($1)`
+
+    rows, err := pool.Query(context.Background(), query, "%"+inputName+"%")
+    if err != nil {
+        apierrors.Abort(c, apierrors.CodeInternal, "Error querying users")
+        return
     }
+    defer rows.Close()
 
-	for userType, query := range queries {
-        rows, err := pool.Query(context.Background(), query, "%"+inputName+"%")
+    for rows.Next() {
+        var user CombinedUser
+        err := rows.Scan(&user.UserID, &user.FirstName, &user.LastName)
         if err != nil {
-            c.JSON(http.StatusInternalServerError, gin.H{"error": "Error querying " + userType + " table"})
-            return
-        }
-        defer rows.Close()
-
-        for rows.Next() {
-            var user CombinedUser
-            err := rows.Scan(&user.UserID, &user.FirstName, &user.LastName)
-            if err != nil {
-                continue  
-            }
-            combinedUsers = append(combinedUsers, user)
+            continue  
         }
+        combinedUsers = append(combinedUsers, user)
     }
 	
     c.JSON(http.StatusOK, gin.H{"users": combinedUsers})
@@ -188,7 +202,7 @@ func GetMessagesForChat(db *pgx.Conn, c *gin.Context) {
     WHERE chat_id = $1 AND deleted_at IS NULL
     ORDER BY created_at ASC;`, chatID)
     if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve messages"})
+        apierrors.Abort(c, apierrors.CodeInternal, "Failed to retrieve messages")
         return
     }
     defer rows.Close()
@@ -197,7 +211,7 @@ func GetMessagesForChat(db *pgx.Conn, c *gin.Context) {
     for rows.Next() {
         var msg models.Message
         if err := rows.Scan(&msg.ID, &msg.ChatID, &msg.SenderID, &msg.Content, &msg.CreatedAt, &msg.UpdatedAt); err != nil {
-            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve messages"})
+            apierrors.Abort(c, apierrors.CodeInternal, "Failed to retrieve messages")
             log.Println("Failed to retrieve messages : ", err)
             return
         }
@@ -263,13 +277,13 @@ func findOrCreateChatWithUser(db *pgx.Conn, currentUserID, selectedUserID string
 }
 
 func FindOrCreateChatWithUser(db *pgx.Conn, c *gin.Context) {
-    currentUserID := c.Query("currentUserId")
+    currentUserID := auth.GetUserID(c)
     selectedUserID := c.Query("selectedUserId")
     log.Println("currentUserId: ", currentUserID)
     log.Println("selectedUserId: ", selectedUserID)
     chatID, err := findOrCreateChatWithUser(db, currentUserID, selectedUserID)
     if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        apierrors.Internal(c, "Error finding or creating chat", err)
         return
     }
 
@@ -2,13 +2,13 @@ package services
 
 import (
 	"context"
-	"crypto/rand"
-	"encoding/hex"
 	"fmt"
 	"log"
 	"net/http"
 	"strings"
+	"tbibi_back_end_go/apierrors"
 	"tbibi_back_end_go/auth"
+	"tbibi_back_end_go/emails"
 	"tbibi_back_end_go/models"
 	"tbibi_back_end_go/validators"
 	"time"
@@ -18,46 +18,34 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
-func emailExists(conn *pgxpool.Conn, email string, c *gin.Context) (bool, error) {
-	var existingEmail string
-	err := conn.QueryRow(c, "SELECT email FROM doctor_info WHERE email = $1", email).Scan(&existingEmail)
-	if err != nil {
-		if err.Error() == "no rows in result set" {
-			return false, nil
-		}
-		return false, err
-	}
-	return true, nil
-}
-
-
-
 // RegisterDoctor registers a new doctor
 func RegisterDoctor(c *gin.Context, pool *pgxpool.Pool) {
 	var doctor models.Doctor
 
-	if err := c.ShouldBindJSON(&doctor); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad Request"})
+	if !validators.BindJSON(c, &doctor) {
+		return
+	}
+
+	if rejectWeakPassword(c, doctor.Password, doctor.Email, doctor.Username, doctor.FirstName, doctor.LastName) {
 		return
 	}
 
 	conn, err := pool.Acquire(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not acquire database connection"})
+		apierrors.Abort(c, apierrors.CodeInternal, "Could not acquire database connection")
 		return
 	}
 	defer conn.Release()
 
-	// checking if the gmail already exists
-	exists, err := emailExists(conn, doctor.Email, c)
+	// checking if the email is already used by any account
+	exists, err := accountEmailExists(c, conn, doctor.Email)
 	if err != nil {
-		log.Printf("Error checking email: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		apierrors.Internal(c, "Error checking email", err)
 		return
 	}
 
 	if exists {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Email already exists"})
+		apierrors.Abort(c, apierrors.CodeEmailTaken, "Email already exists")
 		return
 	}
 
@@ -66,34 +54,26 @@ func RegisterDoctor(c *gin.Context, pool *pgxpool.Pool) {
 	err = conn.QueryRow(c, "SELECT username FROM doctor_info WHERE username = $1", doctor.Username).Scan(&username)
 	if err != nil {
 		if err.Error() != "no rows in result set" {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			apierrors.Abort(c, apierrors.CodeInternal, "Internal server error")
 			return
 		} 
 
 	} else {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Username already exists"})
-		return
-	}
-
-	// Generating Salt and Hash Password
-	saltBytes := make([]byte, 16)
-	_, err = rand.Read(saltBytes)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		apierrors.Abort(c, apierrors.CodeUsernameTaken, "Username already exists")
 		return
 	}
-	salt := hex.EncodeToString(saltBytes)
 
+	// Hashing the password, bcrypt salts it itself
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(doctor.Password), bcrypt.DefaultCost)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		apierrors.Abort(c, apierrors.CodeInternal, "Internal server error")
 		return
 	}
 
 	
 	birthDate, err := time.Parse("2006-01-02", doctor.BirthDate)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad Request"})
+		apierrors.Abort(c, apierrors.CodeInvalidRequest, "Bad Request")
 		return
 	}
 	doctor.Age = time.Now().Year() - birthDate.Year()
@@ -102,16 +82,34 @@ func RegisterDoctor(c *gin.Context, pool *pgxpool.Pool) {
 	// Location
 	doctor.Location = fmt.Sprintf("%s, %s, %s, %s, %s", doctor.StreetAddress, doctor.ZipCode, doctor.CityName, doctor.StateName, doctor.CountryName)
 
-	_, err = conn.Exec(c, `
+	if doctor.TimeZone == "" {
+		doctor.TimeZone = models.DefaultPracticeTimeZone
+	}
+
+	// The account and the profile are created together
+	tx, err := conn.Begin(c)
+	if err != nil {
+		apierrors.Abort(c, apierrors.CodeInternal, "Internal server error")
+		return
+	}
+	defer tx.Rollback(c)
+
+	language := emails.LanguageFromRequest(doctor.PreferredLanguage, c.GetHeader("Accept-Language"))
+	doctor.DoctorID, err = insertAccount(c, tx, doctor.Email, hashedPassword, "doctor", language)
+	if err != nil {
+		apierrors.Internal(c, "Error creating account", err)
+		return
+	}
+
+	_, err = tx.Exec(c, `
 	INSERT INTO doctor_info (
 		doctor_id, 
+		user_id, 
 		username, 
 		first_name, 
 		last_name, 
 		age, 
 		sex, 
-		hashed_password, 
-		salt, 
 		specialty, 
 		experience, 
 		rating_score, 
@@ -128,21 +126,21 @@ func RegisterDoctor(c *gin.Context, pool *pgxpool.Pool) {
 		zip_code, 
 		country_name, 
 		birth_date, 
-		location
+		location,
+		time_zone
 	) 
 	VALUES (
-		uuid_generate_v4(),
-		$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13,
+		$1, $1,
+		$2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13,
 		$14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24
 	)`, 
 
+	doctor.DoctorID,
 	doctor.Username,
 	doctor.FirstName, 
 	doctor.LastName, 
 	doctor.Age, 
 	doctor.Sex, 
-	hashedPassword, 
-	salt, 
 	doctor.Specialty, 
 	doctor.Experience, 
 	nil, 
@@ -160,99 +158,44 @@ func RegisterDoctor(c *gin.Context, pool *pgxpool.Pool) {
 	doctor.CountryName, 
 	doctor.BirthDate, 
 	doctor.Location,
+	doctor.TimeZone,
 		
 	)	
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		apierrors.Abort(c, apierrors.CodeInternal, "Internal server error")
 		return
 	}
 
-	verificationLink := validators.GenerateVerificationLink(doctor.Email, c, pool)
+	verificationLink := validators.GenerateVerificationLink(doctor.Email, tx)
 	if verificationLink == "" {
 		// Handle the error if the link couldn't be generated
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate verification link"})
+		apierrors.Abort(c, apierrors.CodeInternal, "Could not generate verification link")
 		return
 	}
 
-	// Send the verification email
-	err = validators.SendVerificationEmail(doctor.Email, verificationLink) 
+	// Queue the verification email, it is only sent once the account is committed
+	err = validators.QueueVerificationEmail(c, tx, doctor.Email, language, verificationLink)
 	if err != nil {
-		// Log the error and send a response to the user
-		log.Printf("Failed to send verification email: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification email"})
+		apierrors.Internal(c, "Failed to queue verification email", err)
+		return
+	}
+
+	if err := tx.Commit(c); err != nil {
+		apierrors.Abort(c, apierrors.CodeInternal, "Internal server error")
 		return
 	}
 
 	// Respond to the user
 	c.JSON(http.StatusCreated, gin.H{
-		"success": "true",
+		"success": true,
 		"message": "Doctor created successfully. Please check your email to verify your account.",
 	})
 }
 
-func doctorToAuthUser(d *models.Doctor) auth.User {
-	return auth.User{ID: d.Email}  
-}
-
+// LoginDoctor is kept for older clients, it only accepts doctor accounts
 func LoginDoctor(c *gin.Context, pool *pgxpool.Pool) {
-	var loginReq models.LoginRequest
-
-	if err := c.ShouldBindJSON(&loginReq); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
-		return
-	}
-
-	// check if the account is verified
-	var isVerified bool
-	ctx := context.Background()
-	err := pool.QueryRow(ctx, "SELECT is_verified FROM doctor_info WHERE email = $1", loginReq.Email).Scan(&isVerified)
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "message": "Account Not Verified"})
-		return	
-	}
-
-	if !isVerified {
-		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "message": "Account Not Verified, Please check your email to verify your account."})
-		return
-	}
-
-	// Fetch the doctor from the database based on the email
-	var doctor models.Doctor
-	ctx = context.Background()
-	err = pool.QueryRow(ctx, "SELECT email, hashed_password FROM doctor_info WHERE email = $1", loginReq.Email).Scan(
-	&doctor.Email,
-	&doctor.Password,
-	)
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "message": "Invalid email or password"})
-		return
-	}
-	// Comparing the stored hashed password, with the hashed version of the password that was received
-	err = bcrypt.CompareHashAndPassword([]byte(doctor.Password), []byte(loginReq.Password))
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "message": "Invalid email or password"})
-		return
-	}
-
-	// generating a session token
-	user := doctorToAuthUser(&doctor)
-	token, err := auth.GenerateToken(user, "doctor")
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
-		return
-	}
-
-	// get user id 
-	err = pool.QueryRow(ctx, "SELECT doctor_id FROM doctor_info WHERE email = $1", loginReq.Email).Scan(
-		&doctor.DoctorID,
-	)
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "message": "Invalid email or password"})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{"success": true, "token": token, "doctor_id": doctor.DoctorID})
+	loginAccount(c, pool, "doctor")
 }
 
 
@@ -262,7 +205,8 @@ func GetDoctorById(c *gin.Context, pool *pgxpool.Pool) {
 	var doctor models.Doctor
 	doctor.DoctorID = doctorId
 
-    err := pool.QueryRow(context.Background(), "SELECT email, phone_number, first_name, last_name, TO_CHAR(birth_date, 'YYYY-MM-DD'), doctor_bio, sex, location, specialty, rating_score, rating_count  FROM doctor_info WHERE doctor_id = $1", doctor.DoctorID).Scan(
+	var licenseStatus string
+    err := pool.QueryRow(context.Background(), "SELECT email, phone_number, first_name, last_name, TO_CHAR(birth_date, 'YYYY-MM-DD'), doctor_bio, sex, location, specialty, rating_score, rating_count, license_status, time_zone  FROM doctor_info WHERE doctor_id = $1", doctor.DoctorID).Scan(
         &doctor.Email,
         &doctor.PhoneNumber,
         &doctor.FirstName, 
@@ -274,18 +218,25 @@ func GetDoctorById(c *gin.Context, pool *pgxpool.Pool) {
 		&doctor.Specialty,
 		&doctor.RatingScore,
 		&doctor.RatingCount,
+		&licenseStatus,
+		&doctor.TimeZone,
     )
     
     if err != nil {
         if err.Error() == "no rows in result set" {
-            c.JSON(http.StatusNotFound, gin.H{"error": "Doctor not found"})
+            apierrors.Abort(c, apierrors.CodeNotFound, "Doctor not found")
         } else {
-            log.Println("Database error:", err)
-            c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+            apierrors.Internal(c, "Database error", err)
         }
         return
     }
 
+	// Doctors awaiting license approval are only visible to themselves and admins
+	if licenseStatus != models.LicenseStatusApproved && auth.GetUserID(c) != doctor.DoctorID && !auth.HasRole(c, auth.RoleAdmin) {
+		apierrors.Abort(c, apierrors.CodeNotFound, "Doctor not found")
+		return
+	}
+
     c.JSON(http.StatusOK, doctor) 
 }
 
@@ -297,30 +248,28 @@ func GetAllDoctors(c *gin.Context, pool *pgxpool.Pool) {
 	specialty := c.DefaultQuery("specialty", "")
 	location := c.DefaultQuery("location", "")
 
+	// Only doctors whose license was approved by an admin are listed
 	sqlQuery := "SELECT doctor_id, username, first_name, last_name, specialty, experience, rating_score,rating_count, location FROM doctor_info"
-	var conditions []string
-	var queryParams []interface{}
-
-	if query != "" || specialty != "" || location != "" {
-		sqlQuery += " WHERE "
-		if query != "" {
-			conditions = append(conditions, fmt.Sprintf("(first_name ILIKE $%d OR last_name ILIKE $%d)", len(queryParams)+1, len(queryParams)+1))
-			queryParams = append(queryParams, "%"+query+"%", "%"+query+"%")
-		}
-		if specialty != "" {
-			conditions = append(conditions, fmt.Sprintf("specialty ILIKE $%d", len(queryParams)+1))
-			queryParams = append(queryParams, "%"+specialty+"%")
-		}
-		if location != "" {
-			conditions = append(conditions, fmt.Sprintf("location ILIKE $%d", len(queryParams)+1))
-			queryParams = append(queryParams, "%"+location+"%")
-		}
-		sqlQuery += strings.Join(conditions, " AND ")
+	conditions := []string{"license_status = $1"}
+	queryParams := []interface{}{models.LicenseStatusApproved}
+
+	if query != "" {
+		conditions = append(conditions, fmt.Sprintf("(first_name ILIKE $%d OR last_name ILIKE $%d)", len(queryParams)+1, len(queryParams)+1))
+		queryParams = append(queryParams, "%"+query+"%")
+	}
+	if specialty != "" {
+		conditions = append(conditions, fmt.Sprintf("specialty ILIKE $%d", len(queryParams)+1))
+		queryParams = append(queryParams, "%"+specialty+"%")
 	}
+	if location != "" {
+		conditions = append(conditions, fmt.Sprintf("location ILIKE $%d", len(queryParams)+1))
+		queryParams = append(queryParams, "%"+location+"%")
+	}
+	sqlQuery += " WHERE " + strings.Join(conditions, " AND ")
 
 	rows, err := pool.Query(context.Background(), sqlQuery, queryParams...)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apierrors.Internal(c, "Error querying doctors", err)
 		return
 	}
 	defer rows.Close()
@@ -339,7 +288,7 @@ func GetAllDoctors(c *gin.Context, pool *pgxpool.Pool) {
 			&doctor.Location,
 		)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			apierrors.Internal(c, "Error querying doctors", err)
 
 			return
 		}
@@ -349,3 +298,39 @@ func GetAllDoctors(c *gin.Context, pool *pgxpool.Pool) {
 
 	c.JSON(http.StatusOK, doctors)
 }
+
+// UpdateDoctorProfile applies a partial update to the authenticated doctor's profile.
+// A new medical license number has to be reviewed by an admin again.
+func UpdateDoctorProfile(c *gin.Context, pool *pgxpool.Pool) {
+	var request models.DoctorProfileUpdate
+	if !validators.BindJSON(c, &request) {
+		return
+	}
+
+	update := &profileUpdate{}
+	update.set("phone_number", "PhoneNumber", request.PhoneNumber, true)
+	update.set("first_name", "FirstName", request.FirstName, true)
+	update.set("last_name", "LastName", request.LastName, true)
+	update.setBirthDate(request.BirthDate)
+	update.set("street_address", "StreetAddress", request.StreetAddress, true)
+	update.set("city_name", "CityName", request.CityName, true)
+	update.set("state_name", "StateName", request.StateName, false)
+	update.set("zip_code", "ZipCode", request.ZipCode, true)
+	update.set("country_name", "CountryName", request.CountryName, true)
+	update.set("sex", "Sex", request.Sex, true)
+	update.set("specialty", "Specialty", request.Specialty, true)
+	update.set("experience", "Experience", request.Experience, true)
+	update.set("doctor_bio", "DoctorBio", request.DoctorBio, false)
+	update.set("medical_license", "MedicalLicense", request.MedicalLicense, true)
+	update.set("time_zone", "TimeZone", request.TimeZone, true)
+
+	if request.MedicalLicense != nil {
+		update.before = append(update.before, profileStatement{
+			sql: `UPDATE doctor_info SET license_status = $1, license_reviewed_at = NULL, license_reviewed_by = NULL, license_rejection_reason = NULL
+				WHERE doctor_id = $2 AND medical_license IS DISTINCT FROM $3`,
+			args: []interface{}{models.LicenseStatusPending, auth.GetUserID(c), strings.TrimSpace(*request.MedicalLicense)},
+		})
+	}
+
+	updateProfile(c, pool, "doctor_info", "doctor_id", request.Email, update)
+}
@@ -10,7 +10,11 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
+	"tbibi_back_end_go/apierrors"
+	"tbibi_back_end_go/auth"
 	"tbibi_back_end_go/models"
+	"tbibi_back_end_go/validators"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -21,20 +25,19 @@ import (
 func CreateFolder(c *gin.Context, pool *pgxpool.Pool) {
 	// Parsing the form data
     var fileFolder models.FileFolder
-    if err := c.ShouldBind(&fileFolder); err != nil {
-        log.Println("Error parsing form:", err)
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse form"})
+    if !validators.Bind(c, &fileFolder) {
         return
     }
 	
 	// Generating a new UUID for the folder
 	folderUUID, err := uuid.NewRandom()
 	if err != nil {
-        log.Println("Error generating folder UUID:", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate folder UUID"})
+		apierrors.Internal(c, "Error generating folder UUID", err)
 		return
 	}
 	fileFolder.ID = folderUUID.String()
+	fileFolder.UserID = auth.GetUserID(c)
+	fileFolder.UserType = auth.GetUserType(c)
 	fileFolder.CreatedAt = time.Now()
 	fileFolder.UpdatedAt = time.Now()
 
@@ -47,13 +50,21 @@ func CreateFolder(c *gin.Context, pool *pgxpool.Pool) {
 	// Create a new folder in the uploads directory
 	folderPath := filepath.Join("./uploads", fileFolder.UserID)
 	if fileFolder.ParentID != nil && *fileFolder.ParentID != "" {
+        owned, err := auth.IsFolderFileOwner(c.Request.Context(), pool, *fileFolder.ParentID, fileFolder.UserID)
+        if err != nil {
+            apierrors.Internal(c, "Error checking parent folder owner", err)
+            return
+        }
+        if !owned {
+            auth.AbortForbidden(c)
+            return
+        }
         var parentFolderPath string
         if fileFolder.ParentID != nil {
             parentFolderPath, err = getParentFolderPath(*fileFolder.ParentID, pool)
         }
             if err != nil {
-                log.Println("Error retrieving parent folder path:", err)
-                c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+                apierrors.Internal(c, "Error retrieving parent folder path", err)
                 return
             }
             folderPath = filepath.Join(folderPath, parentFolderPath)
@@ -63,16 +74,14 @@ func CreateFolder(c *gin.Context, pool *pgxpool.Pool) {
     fileFolder.Path = folderPath
     err = os.MkdirAll(folderPath, 0755)
     if err != nil {
-        log.Println("Error creating folder:", err)
-        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        apierrors.Internal(c, "Error creating folder", err)
         return
     }
 
 	// Acquiring a connection from the connection pool
 	conn, err := pool.Acquire(c.Request.Context())
 	if err != nil {
-        log.Println("Error acquiring connection:", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not acquire database connection"})
+		apierrors.Internal(c, "Error acquiring connection", err)
 		return
 	}
 	defer conn.Release()
@@ -81,7 +90,7 @@ func CreateFolder(c *gin.Context, pool *pgxpool.Pool) {
 	tx, err := conn.Begin(c.Request.Context())
 	if err != nil {
         log.Println("Error beginning transaction:", err )
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not begin transaction"})
+		apierrors.Abort(c, apierrors.CodeInternal, "Could not begin transaction")
 		return
 	}
 
@@ -93,14 +102,14 @@ func CreateFolder(c *gin.Context, pool *pgxpool.Pool) {
 	if err != nil {
         log.Println("Error inserting folder info:", err )
 		tx.Rollback(c.Request.Context())
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not insert folder info"})
+		apierrors.Abort(c, apierrors.CodeInternal, "Could not insert folder info")
 		return
 	}
 
 	// Committing the transaction
 	if err := tx.Commit(c.Request.Context()); err != nil {
         log.Println("Error committing transaction:", err )
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not commit transaction"})
+		apierrors.Abort(c, apierrors.CodeInternal, "Could not commit transaction")
 		return
 	}
 
@@ -113,8 +122,7 @@ func GetBreadcrumbs(c *gin.Context, pool *pgxpool.Pool) {
 	// Acquiring a connection from the connection pool
     breadcrumbs, err := getParentFolders(folderID, pool)
     if err != nil {
-        log.Println("Error getting parent folders:", err)
-        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        apierrors.Internal(c, "Error getting parent folders", err)
         return
     }
     c.JSON(http.StatusOK, breadcrumbs)
@@ -166,15 +174,14 @@ func GetFolders(c *gin.Context, pool *pgxpool.Pool) {
 	// Acquiring a connection from the connection pool
 	conn, err := pool.Acquire(c.Request.Context())
     if err != nil {
-        log.Println("Error acquiring connection:", err)
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not acquire database connection"})
+        apierrors.Internal(c, "Error acquiring connection", err)
         return
     }
     defer conn.Release()
 
-	// Extracting the query parameters
-	userID := c.DefaultQuery("user_id", "")
-    userType := c.DefaultQuery("user_type", "")
+	// Extracting the authenticated user and the query parameters
+	userID := auth.GetUserID(c)
+    userType := auth.GetUserType(c)
 	parentID := c.Query("parent_id")
 
 
@@ -195,8 +202,7 @@ func GetFolders(c *gin.Context, pool *pgxpool.Pool) {
     // Executing the query with the prepared arguments
     rows, err := conn.Query(c.Request.Context(), baseQuery, args...)
     if err != nil {
-        log.Println("Error executing query:", err)
-        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        apierrors.Internal(c, "Error executing query", err)
         return
     }
     defer rows.Close()
@@ -211,8 +217,7 @@ func GetFolders(c *gin.Context, pool *pgxpool.Pool) {
         err := rows.Scan(&folder.ID, &folder.Name, &folder.CreatedAt, &folder.UpdatedAt, &folder.Type, &folder.Ext, &path)
 		
 		if err != nil {
-            log.Println("Error scanning row:", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			apierrors.Internal(c, "Error scanning row", err)
             return
         }
         if path != nil {
@@ -235,8 +240,7 @@ func GetSubfolders(c *gin.Context, pool *pgxpool.Pool) {
     // Acquiring a connection from the connection pool
     conn, err := pool.Acquire(c.Request.Context())
     if err != nil {
-        log.Println("Error acquiring connection:", err)
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not acquire database connection"})
+        apierrors.Internal(c, "Error acquiring connection", err)
         return
     }
     defer conn.Release()
@@ -247,8 +251,7 @@ func GetSubfolders(c *gin.Context, pool *pgxpool.Pool) {
     // Executing the query with the parentID as the parameter
     rows, err := conn.Query(c.Request.Context(), query, parentID)
     if err != nil {
-        log.Println("Error executing query:", err)
-        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        apierrors.Internal(c, "Error executing query", err)
         return
     }
     defer rows.Close()
@@ -261,15 +264,13 @@ func GetSubfolders(c *gin.Context, pool *pgxpool.Pool) {
         var folder models.FileFolder
         err := rows.Scan(&folder.ID, &folder.Name, &folder.CreatedAt, &folder.UpdatedAt)
         if err != nil {
-            log.Println("Error scanning row:", err)
-            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            apierrors.Internal(c, "Error scanning row", err)
             return
         }
         subfolders = append(subfolders, folder)
     }
     if err = rows.Err(); err != nil {
-        log.Println("Error scanning row:", err)
-        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        apierrors.Internal(c, "Error scanning row", err)
         return
     }
     c.JSON(http.StatusOK, subfolders)
@@ -277,22 +278,16 @@ func GetSubfolders(c *gin.Context, pool *pgxpool.Pool) {
 
 
 func DeleteFolderAndContents(c *gin.Context, pool *pgxpool.Pool) {
-    // Parse the JSON body to get the folder ID to delete
-
+    // The folder ID comes from the path, ownership was checked by the route policy
     var request struct {
-        FolderID string `json:"folderId"`
-    }
-    if err := c.ShouldBindJSON(&request); err != nil {
-        log.Println("Error parsing JSON:", err)
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
-        return
+        FolderID string
     }
+    request.FolderID = c.Param("folderId")
 
     // Start a transaction
     tx, err := pool.Begin(c.Request.Context())
     if err != nil {
-        log.Println("Error beginning transaction:", err)
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not begin transaction"})
+        apierrors.Internal(c, "Error beginning transaction", err)
         return
     }
     defer tx.Rollback(c.Request.Context())
@@ -309,23 +304,13 @@ func DeleteFolderAndContents(c *gin.Context, pool *pgxpool.Pool) {
         `
     // Execute the CTE query to delete all subfolders and files in the database
     if _, err := tx.Exec(c.Request.Context(), cteQuery, request.FolderID); err != nil {
-        log.Println("Error deleting folder contents:", err)
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not delete folder contents"})
-        return
-    }
-
-    // Commit the transaction - This is missing from the code snippet provided
-    if err := tx.Commit(c.Request.Context()); err != nil {
-        log.Println("Error committing transaction:", err)
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not commit transaction"})
+        apierrors.Internal(c, "Error deleting folder contents", err)
         return
     }
 
     // Commit the transaction
-    _, err = tx.Exec(c.Request.Context(), "DELETE FROM folder_file_info WHERE id = $1", request.FolderID)
-    if err != nil {
-        log.Println("Error deleting root folder:", err)
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not delete root folder"})
+    if err := tx.Commit(c.Request.Context()); err != nil {
+        apierrors.Internal(c, "Error committing transaction", err)
         return
     }
 
@@ -333,7 +318,7 @@ func DeleteFolderAndContents(c *gin.Context, pool *pgxpool.Pool) {
     folderPath := filepath.Join("./uploads", request.FolderID)
     if err := os.RemoveAll(folderPath); err != nil {
         log.Printf("Error deleting folder from filesystem: %s\n", err)
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not delete folder from filesystem"})
+        apierrors.Abort(c, apierrors.CodeInternal, "Could not delete folder from filesystem")
         return
     }
 
@@ -343,19 +328,16 @@ func DeleteFolderAndContents(c *gin.Context, pool *pgxpool.Pool) {
 func UpdateFolderName(c *gin.Context, pool *pgxpool.Pool) {
     folderID := c.Param("folderId")
     var updateRequest struct {
-        Name string `json:"name"`
+        Name string `json:"name" binding:"required,notblank,max=50,filename"`
     }
 
-    if err := c.ShouldBindJSON(&updateRequest); err != nil {
-        log.Println("Error parsing JSON:", err)
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+    if !validators.BindJSON(c, &updateRequest) {
         return
     }
 
     conn, err := pool.Acquire(c.Request.Context())
     if err != nil {
-        log.Println("Error acquiring connection:", err)
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not acquire database connection"})
+        apierrors.Internal(c, "Error acquiring connection", err)
         return
     }
     defer conn.Release()
@@ -366,8 +348,7 @@ func UpdateFolderName(c *gin.Context, pool *pgxpool.Pool) {
         updateRequest.Name, time.Now(), folderID)
 
     if err != nil {
-        log.Println("Error updating folder name:", err)
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not update folder name"})
+        apierrors.Internal(c, "Error updating folder name", err)
         return
     }
 
@@ -429,14 +410,14 @@ func UploadFile(c *gin.Context, pool *pgxpool.Pool) {
     err := c.Request.ParseMultipartForm(10 << 20) // 10 MB
     if err != nil {
         log.Println("Error parsing multipart form:", err)
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Could not parse multipart form"})
+        apierrors.Abort(c, apierrors.CodeInvalidRequest, "Could not parse multipart form")
         return
     }
 
     file, handler, err := c.Request.FormFile("file")
     if err != nil {
         log.Println("Error retrieving file from request:", err)
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Could not get file from request"})
+        apierrors.Abort(c, apierrors.CodeInvalidRequest, "Could not get file from request")
         return
     }
     defer file.Close()
@@ -445,7 +426,17 @@ func UploadFile(c *gin.Context, pool *pgxpool.Pool) {
     parentFolderID != "" {
         if _, err := uuid.Parse(parentFolderID); err != nil {
             log.Printf("Invalid parentFolderId: %s\n", err)
-            c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid parentFolderId"})
+            apierrors.Abort(c, apierrors.CodeInvalidRequest, "Invalid parentFolderId")
+            return
+        }
+        owned, err := auth.IsFolderFileOwner(c.Request.Context(), pool, parentFolderID, auth.GetUserID(c))
+        if err != nil {
+            log.Printf("Error checking parent folder owner: %s\n", err)
+            apierrors.Abort(c, apierrors.CodeInternal, "Could not check parent folder")
+            return
+        }
+        if !owned {
+            auth.AbortForbidden(c)
             return
         }
         fileInfo.ParentID = &parentFolderID
@@ -459,8 +450,8 @@ func UploadFile(c *gin.Context, pool *pgxpool.Pool) {
     fileInfo.Type = c.Request.FormValue("fileType")
     ext := c.Request.FormValue("fileExt")
     fileInfo.Ext = &ext
-    fileInfo.UserID = c.Request.FormValue("userId")
-    fileInfo.UserType = c.Request.FormValue("userType")
+    fileInfo.UserID = auth.GetUserID(c)
+    fileInfo.UserType = auth.GetUserType(c)
     fileInfo.Size = handler.Size
     fileInfo.Name = handler.Filename
     id, _ := uuid.NewRandom()
@@ -475,7 +466,7 @@ func UploadFile(c *gin.Context, pool *pgxpool.Pool) {
     filePath, err = generateFilePath(fileInfo.UserID, parentID, fileInfo.Name, pool)
     if err != nil {
         log.Printf("Error generating file path: %s\n", err)
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate file path"})
+        apierrors.Abort(c, apierrors.CodeInternal, "Failed to generate file path")
         return
     }
 
@@ -484,35 +475,35 @@ func UploadFile(c *gin.Context, pool *pgxpool.Pool) {
     newFile, err := os.Create(filePath)
     if err != nil {
         log.Printf("Error creating file: %s\n", err)
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create file"})
+        apierrors.Abort(c, apierrors.CodeInternal, "Failed to create file")
         return
     }
 
     _, err = file.Seek(0, 0)
     if err != nil {
         log.Printf("Error seeking to beginning of file: %s\n", err)
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to seek to beginning of file"})
+        apierrors.Abort(c, apierrors.CodeInternal, "Failed to seek to beginning of file")
         return
     }
 
     _, err = newFile.Seek(0, 0)
     if err != nil {
         log.Printf("Error seeking to beginning of new file: %s\n", err)
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to seek to beginning of new file"})
+        apierrors.Abort(c, apierrors.CodeInternal, "Failed to seek to beginning of new file")
         return
     }
 
     _, err = io.Copy(newFile, file)
     if err != nil {
         log.Printf("Error copying file data: %s\n", err)    
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to copy file data"})
+        apierrors.Abort(c, apierrors.CodeInternal, "Failed to copy file data")
         return
     }
 
     // Close the file
     if err = newFile.Close(); err != nil {
         log.Printf("Error closing file: %s\n", err)
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to close file"})
+        apierrors.Abort(c, apierrors.CodeInternal, "Failed to close file")
         return
     }
 
@@ -521,13 +512,25 @@ func UploadFile(c *gin.Context, pool *pgxpool.Pool) {
         fileInfo.ID, fileInfo.Name, fileInfo.CreatedAt, fileInfo.UpdatedAt, fileInfo.Type, fileInfo.Size, fileInfo.Ext, fileInfo.UserID, fileInfo.UserType, fileInfo.ParentID, fileInfo.Path)
     if err != nil { 
         log.Printf("Error inserting file info: %s\n", err)
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to insert file info"})
+        apierrors.Abort(c, apierrors.CodeInternal, "Failed to insert file info")
         return
     }
     c.JSON(http.StatusOK, gin.H{"message": "File uploaded successfully"})
 }
 
 
+// ServeUserFile serves a file from the authenticated user's own uploads directory
+func ServeUserFile(c *gin.Context) {
+    relativePath := filepath.Clean("/" + c.Param("filepath"))
+    parts := strings.SplitN(strings.TrimPrefix(relativePath, "/"), "/", 2)
+    if parts[0] != auth.GetUserID(c) {
+        auth.AbortForbidden(c)
+        return
+    }
+    c.File(filepath.Join("./uploads", relativePath))
+}
+
+
 func DownloadFile(c *gin.Context, pool *pgxpool.Pool) {
     log.Println("DownloadFile function called")
 
@@ -537,8 +540,7 @@ func DownloadFile(c *gin.Context, pool *pgxpool.Pool) {
     // Acquire a connection from the pool
     conn, err := pool.Acquire(c.Request.Context())
     if err != nil {
-        log.Printf("Error acquiring connection: %v", err)
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not acquire database connection"})
+        apierrors.Internal(c, "Error acquiring connection", err)
         return
     }
     defer conn.Release()
@@ -547,8 +549,7 @@ func DownloadFile(c *gin.Context, pool *pgxpool.Pool) {
     var file models.FileFolder
     err = conn.QueryRow(c.Request.Context(), "SELECT id, name, path FROM folder_file_info WHERE id = $1", fileId).Scan(&file.ID, &file.Name, &file.Path)
     if err != nil {
-        log.Printf("Error retrieving file information: %v", err)
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not retrieve file information"})
+        apierrors.Internal(c, "Error retrieving file information", err)
         return
     }
 
@@ -558,8 +559,7 @@ func DownloadFile(c *gin.Context, pool *pgxpool.Pool) {
         log.Println("Processing as a folder")
         zipFilePath, err := createZipFromFolder(file.Path)
         if err != nil {
-            log.Printf("Error creating zip file: %v", err)
-            c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create zip file"})
+            apierrors.Internal(c, "Error creating zip file", err)
             return
         }
         log.Printf("Zip file created: %s", zipFilePath)    
@@ -0,0 +1,177 @@
+package services
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"tbibi_back_end_go/apierrors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Reasons recorded in login_attempts.failure_reason
+const (
+	loginFailureUnknownAccount = "unknown_account"
+	loginFailureWrongPassword  = "wrong_password"
+	loginFailureNotVerified    = "not_verified"
+	loginFailureWrongMFACode   = "wrong_mfa_code"
+	loginFailureLocked         = "locked"
+)
+
+// Lockout thresholds. An email is locked after accountFailureThreshold failures
+// since its last successful login, an IP after ipFailureThreshold failures
+// within ipFailureWindow. Every further failure doubles the lockout, starting
+// at lockoutBase and capped at lockoutMax.
+const (
+	accountFailureThreshold = 5
+	accountFailureWindow    = 24 * time.Hour
+	ipFailureThreshold      = 20
+	ipFailureWindow         = time.Hour
+	lockoutBase             = time.Minute
+	lockoutMax              = time.Hour
+)
+
+// dummyPasswordHash is compared against when the email is unknown so that
+// unknown and existing accounts take the same time to answer.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("tbibi-dummy-password"), bcrypt.DefaultCost)
+
+type LoginAttempt struct {
+	ID            int64     `json:"id"`
+	Email         string    `json:"email"`
+	IPAddress     string    `json:"ip_address"`
+	UserID        *string   `json:"user_id"`
+	Succeeded     bool      `json:"succeeded"`
+	FailureReason *string   `json:"failure_reason"`
+	UserAgent     string    `json:"user_agent"`
+	AttemptedAt   time.Time `json:"attempted_at"`
+}
+
+// recordLoginAttempt stores the outcome of a login attempt for throttling and auditing
+func recordLoginAttempt(c *gin.Context, pool *pgxpool.Pool, email string, userID string, failureReason string) {
+	var userIDParam, reasonParam interface{}
+	if userID != "" {
+		userIDParam = userID
+	}
+	if failureReason != "" {
+		reasonParam = failureReason
+	}
+
+	_, err := pool.Exec(context.Background(), `
+		INSERT INTO login_attempts (email, ip_address, user_id, succeeded, failure_reason, user_agent, attempted_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NOW())`,
+		strings.ToLower(strings.TrimSpace(email)), c.ClientIP(), userIDParam, failureReason == "", reasonParam, c.Request.UserAgent())
+	if err != nil {
+		log.Println("Error recording login attempt:", err)
+	}
+}
+
+// lockoutRemaining returns how long a key stays locked at now given its failure count and last failure
+func lockoutRemaining(failures int, threshold int, lastFailure time.Time, now time.Time) time.Duration {
+	if failures < threshold {
+		return 0
+	}
+	lockout := time.Duration(float64(lockoutBase) * math.Pow(2, float64(failures-threshold)))
+	if lockout > lockoutMax || lockout <= 0 {
+		lockout = lockoutMax
+	}
+	return lastFailure.Add(lockout).Sub(now)
+}
+
+// loginLockout returns how long the email or the client IP must wait before trying to log in again
+func loginLockout(c *gin.Context, pool *pgxpool.Pool, email string) (time.Duration, error) {
+	ctx := context.Background()
+	counted := []string{loginFailureUnknownAccount, loginFailureWrongPassword, loginFailureWrongMFACode}
+
+	var accountFailures int
+	var accountLastFailure *time.Time
+	err := pool.QueryRow(ctx, `
+		SELECT COUNT(*), MAX(attempted_at) FROM login_attempts
+		WHERE LOWER(email) = LOWER($1)
+		AND NOT succeeded AND failure_reason = ANY($2)
+		AND attempted_at > GREATEST(NOW() - make_interval(secs => $3),
+			COALESCE((SELECT MAX(attempted_at) FROM login_attempts WHERE LOWER(email) = LOWER($1) AND succeeded), '-infinity'))`,
+		strings.TrimSpace(email), counted, accountFailureWindow.Seconds()).Scan(&accountFailures, &accountLastFailure)
+	if err != nil {
+		return 0, err
+	}
+
+	var ipFailures int
+	var ipLastFailure *time.Time
+	err = pool.QueryRow(ctx, `
+		SELECT COUNT(*), MAX(attempted_at) FROM login_attempts
+		WHERE ip_address = $1
+		AND NOT succeeded AND failure_reason = ANY($2)
+		AND attempted_at > NOW() - make_interval(secs => $3)`,
+		c.ClientIP(), counted, ipFailureWindow.Seconds()).Scan(&ipFailures, &ipLastFailure)
+	if err != nil {
+		return 0, err
+	}
+
+	var remaining time.Duration
+	now := time.Now()
+	if accountLastFailure != nil {
+		remaining = lockoutRemaining(accountFailures, accountFailureThreshold, *accountLastFailure, now)
+	}
+	if ipLastFailure != nil {
+		if ipRemaining := lockoutRemaining(ipFailures, ipFailureThreshold, *ipLastFailure, now); ipRemaining > remaining {
+			remaining = ipRemaining
+		}
+	}
+	return remaining, nil
+}
+
+// respondLoginLocked answers a throttled login attempt
+func respondLoginLocked(c *gin.Context, remaining time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(remaining.Seconds()))))
+	apierrors.Abort(c, apierrors.CodeRateLimited, "Too many failed login attempts, please try again later")
+}
+
+// ListLoginAttempts lets admins audit login attempts, filtered by email, IP address and outcome
+func ListLoginAttempts(c *gin.Context, pool *pgxpool.Pool) {
+	query := "SELECT id, email, ip_address, user_id, succeeded, failure_reason, user_agent, attempted_at FROM login_attempts WHERE TRUE"
+	params := []interface{}{}
+
+	if email := c.Query("email"); email != "" {
+		params = append(params, email)
+		query += " AND LOWER(email) = LOWER($" + strconv.Itoa(len(params)) + ")"
+	}
+	if ip := c.Query("ip"); ip != "" {
+		params = append(params, ip)
+		query += " AND ip_address = $" + strconv.Itoa(len(params))
+	}
+	if c.Query("failed_only") == "true" {
+		query += " AND NOT succeeded"
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	params = append(params, limit)
+	query += " ORDER BY attempted_at DESC LIMIT $" + strconv.Itoa(len(params))
+
+	rows, err := pool.Query(context.Background(), query, params...)
+	if err != nil {
+		apierrors.Internal(c, "Query Error", err)
+		return
+	}
+	defer rows.Close()
+
+	attempts := []LoginAttempt{}
+	for rows.Next() {
+		var attempt LoginAttempt
+		if err := rows.Scan(&attempt.ID, &attempt.Email, &attempt.IPAddress, &attempt.UserID, &attempt.Succeeded, &attempt.FailureReason, &attempt.UserAgent, &attempt.AttemptedAt); err != nil {
+			apierrors.Internal(c, "Row Scan Error", err)
+			return
+		}
+		attempts = append(attempts, attempt)
+	}
+
+	c.JSON(http.StatusOK, attempts)
+}
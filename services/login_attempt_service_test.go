@@ -0,0 +1,35 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockoutRemaining(t *testing.T) {
+	lastFailure := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		failures int
+		since    time.Duration // time elapsed since the last failure
+		want     time.Duration
+	}{
+		{"below the threshold", accountFailureThreshold - 1, 0, 0},
+		{"at the threshold", accountFailureThreshold, 0, lockoutBase},
+		{"part of the lockout elapsed", accountFailureThreshold, 20 * time.Second, lockoutBase - 20*time.Second},
+		{"lockout just over", accountFailureThreshold, lockoutBase, 0},
+		{"lockout over", accountFailureThreshold, 2 * lockoutBase, -lockoutBase},
+		{"doubles with each failure", accountFailureThreshold + 1, 0, 2 * lockoutBase},
+		{"doubles again", accountFailureThreshold + 3, 0, 8 * lockoutBase},
+		{"last step under the cap", accountFailureThreshold + 5, 0, 32 * lockoutBase},
+		{"capped", accountFailureThreshold + 6, 0, lockoutMax},
+		{"capped for very many failures", accountFailureThreshold + 1000, 0, lockoutMax},
+	}
+
+	for _, test := range tests {
+		got := lockoutRemaining(test.failures, accountFailureThreshold, lastFailure, lastFailure.Add(test.since))
+		if got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
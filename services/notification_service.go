@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"tbibi_back_end_go/auth"
+	"tbibi_back_end_go/emails"
+	"tbibi_back_end_go/outbox"
+	"time"
+)
+
+// contact is what is needed to email a user
+type contact struct {
+	Email    string
+	Language string
+	Name     string
+}
+
+// contactForUser returns the email, language and display name of a user of any type
+func contactForUser(ctx context.Context, db auth.Querier, userID string) (contact, error) {
+	var to contact
+	err := db.QueryRow(ctx, `
+		SELECT u.email, u.preferred_language,
+			COALESCE(p.first_name || ' ' || p.last_name, d.first_name || ' ' || d.last_name, a.first_name || ' ' || a.last_name, u.email)
+		FROM users u
+		LEFT JOIN patient_info p ON p.user_id = u.user_id
+		LEFT JOIN doctor_info d ON d.user_id = u.user_id
+		LEFT JOIN admin_info a ON a.user_id = u.user_id
+		WHERE u.user_id::text = $1`, userID).Scan(&to.Email, &to.Language, &to.Name)
+	return to, err
+}
+
+// queueEmail renders a notification in the recipient's language and puts it in the outbox.
+// Pass the transaction of the change the email is about as db.
+func queueEmail(ctx context.Context, db auth.Querier, to contact, name string, data interface{}) error {
+	msg, err := emails.Build(to.Email, to.Language, name, data)
+	if err != nil {
+		return fmt.Errorf("rendering %s email: %v", name, err)
+	}
+	return outbox.Enqueue(ctx, db, msg)
+}
+
+// appointmentContacts returns the doctor and the patient of an appointment
+func appointmentContacts(ctx context.Context, db auth.Querier, doctorID, patientID string) (contact, contact, error) {
+	doctor, err := contactForUser(ctx, db, doctorID)
+	if err != nil {
+		return contact{}, contact{}, fmt.Errorf("fetching doctor %s: %v", doctorID, err)
+	}
+	patient, err := contactForUser(ctx, db, patientID)
+	if err != nil {
+		return contact{}, contact{}, fmt.Errorf("fetching patient %s: %v", patientID, err)
+	}
+	return doctor, patient, nil
+}
+
+// notifyAppointmentConfirmed tells the patient their booking went through
+func notifyAppointmentConfirmed(ctx context.Context, db auth.Querier, doctorID, patientID, title string, start, end time.Time) error {
+	doctor, patient, err := appointmentContacts(ctx, db, doctorID, patientID)
+	if err != nil {
+		return err
+	}
+
+	// times are written in the practice time zone, where the appointment takes place
+	location := practiceLocation(ctx, db, doctorID)
+	return queueEmail(ctx, db, patient, emails.AppointmentConfirmed, emails.AppointmentData{
+		RecipientName: patient.Name,
+		DoctorName:    doctor.Name,
+		PatientName:   patient.Name,
+		Title:         title,
+		Start:         start.In(location),
+		End:           end.In(location),
+	})
+}
+
+// notifyAppointmentCancelled tells the doctor and the patient, except the one who cancelled, that an appointment was cancelled
+func notifyAppointmentCancelled(ctx context.Context, db auth.Querier, doctorID, patientID, cancelledBy, title, reason string, start, end time.Time) error {
+	doctor, patient, err := appointmentContacts(ctx, db, doctorID, patientID)
+	if err != nil {
+		return err
+	}
+
+	location := practiceLocation(ctx, db, doctorID)
+	data := emails.AppointmentData{DoctorName: doctor.Name, PatientName: patient.Name, Title: title, Reason: reason,
+		Start: start.In(location), End: end.In(location)}
+	if cancelledBy != doctorID {
+		data.RecipientName = doctor.Name
+		if err := queueEmail(ctx, db, doctor, emails.AppointmentCancelled, data); err != nil {
+			return err
+		}
+	}
+	if cancelledBy != patientID {
+		data.RecipientName = patient.Name
+		if err := queueEmail(ctx, db, patient, emails.AppointmentCancelled, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// notifyAppointmentRescheduled tells the doctor and the patient, except the one who moved it, the new time of an appointment
+func notifyAppointmentRescheduled(ctx context.Context, db auth.Querier, doctorID, patientID, movedBy, title, reason string, previousStart, start, end time.Time) error {
+	doctor, patient, err := appointmentContacts(ctx, db, doctorID, patientID)
+	if err != nil {
+		return err
+	}
+
+	location := practiceLocation(ctx, db, doctorID)
+	data := emails.AppointmentData{DoctorName: doctor.Name, PatientName: patient.Name, Title: title, Reason: reason,
+		Start: start.In(location), End: end.In(location), PreviousStart: previousStart.In(location)}
+	if movedBy != doctorID {
+		data.RecipientName = doctor.Name
+		if err := queueEmail(ctx, db, doctor, emails.AppointmentRescheduled, data); err != nil {
+			return err
+		}
+	}
+	if movedBy != patientID {
+		data.RecipientName = patient.Name
+		if err := queueEmail(ctx, db, patient, emails.AppointmentRescheduled, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// notifyItemsShared tells a user that files or folders were shared with them
+func notifyItemsShared(ctx context.Context, db auth.Querier, sharerID, recipientID string, itemNames []string) error {
+	if len(itemNames) == 0 {
+		return nil
+	}
+	sharer, err := contactForUser(ctx, db, sharerID)
+	if err != nil {
+		return fmt.Errorf("fetching sharer %s: %v", sharerID, err)
+	}
+	recipient, err := contactForUser(ctx, db, recipientID)
+	if err != nil {
+		return fmt.Errorf("fetching recipient %s: %v", recipientID, err)
+	}
+
+	return queueEmail(ctx, db, recipient, emails.ItemShared, emails.ItemSharedData{
+		RecipientName: recipient.Name,
+		SharerName:    sharer.Name,
+		ItemNames:     itemNames,
+	})
+}
@@ -2,12 +2,10 @@ package services
 
 import (
 	"context"
-	"crypto/rand"
-	"encoding/hex"
 	"fmt"
-	"log"
 	"net/http"
-	"tbibi_back_end_go/auth"
+	"tbibi_back_end_go/apierrors"
+	"tbibi_back_end_go/emails"
 	"tbibi_back_end_go/models"
 	"tbibi_back_end_go/validators"
 	"time"
@@ -36,10 +34,9 @@ func GetPatientById(c *gin.Context, pool *pgxpool.Pool) {
     )
     if err != nil {
         if err.Error() == "no rows in result set" {
-            c.JSON(http.StatusNotFound, gin.H{"error": "Patient not found"})
+            apierrors.Abort(c, apierrors.CodeNotFound, "Patient not found")
         } else {
-            log.Println("Database error:", err)
-            c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+            apierrors.Internal(c, "Database error", err)
         }
         return
     }
@@ -50,33 +47,29 @@ func GetPatientById(c *gin.Context, pool *pgxpool.Pool) {
 func RegisterPatient(c *gin.Context, pool *pgxpool.Pool) {
 	// Registering a new patient
 	var patient models.Patient
-    if err := c.ShouldBindJSON(&patient); err != nil {
-		log.Printf("Error binding JSON: %v", err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format", "details": err.Error()})
+	if !validators.BindJSON(c, &patient) {
+		return
+	}
+
+	if rejectWeakPassword(c, patient.Password, patient.Email, patient.Username, patient.FirstName, patient.LastName) {
 		return
 	}
-	log.Printf("Received patient data: %+v", patient)
 
 	conn, err := pool.Acquire(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not acquire database connection"})
+		apierrors.Abort(c, apierrors.CodeInternal, "Could not acquire database connection")
 		return
 	}
 	defer conn.Release()
 
-	// checking if the gmail already exists
-	var email string
-	queryString := "SELECT email FROM patient_info WHERE email = $1"
-	log.Printf("Executing query: %s with email: %s", queryString, patient.Email)
-	err = conn.QueryRow(c, queryString, patient.Email).Scan(&email)
+	// checking if the email is already used by any account
+	exists, err := accountEmailExists(c, conn, patient.Email)
 	if err != nil {
-		log.Printf("Query error: %v", err)
-		if err.Error() != "no rows in result set" {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
-			return
-		}
-	} else {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Email already exists"})
+		apierrors.Internal(c, "Query error", err)
+		return
+	}
+	if exists {
+		apierrors.Abort(c, apierrors.CodeEmailTaken, "Email already exists")
 		return
 	}
 
@@ -85,50 +78,57 @@ func RegisterPatient(c *gin.Context, pool *pgxpool.Pool) {
 	err = conn.QueryRow(c, "SELECT username FROM patient_info WHERE username = $1", patient.Username).Scan(&username)
 	if err != nil {
 		if err.Error() != "no rows in result set" {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			apierrors.Abort(c, apierrors.CodeInternal, "Internal server error")
 			return
 		} 
 
 	} else {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Username already exists"})
+		apierrors.Abort(c, apierrors.CodeUsernameTaken, "Username already exists")
 		return
 	}
 
-	// Generating Salt and Hash Password
-	saltBytes := make([]byte, 16)
-	_, err = rand.Read(saltBytes)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
-		return
-	}
-	salt := hex.EncodeToString(saltBytes)
-
+	// Hashing the password, bcrypt salts it itself
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(patient.Password), bcrypt.DefaultCost)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		apierrors.Abort(c, apierrors.CodeInternal, "Internal server error")
 		return
 	}
 
 	//  Age
 	birthDate, err := time.Parse("2006-01-02", patient.BirthDate)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad Request"})
+		apierrors.Abort(c, apierrors.CodeInvalidRequest, "Bad Request")
 		return
 	}
 	var age = time.Now().Year() - birthDate.Year()
 
 	// Location
 	var location = fmt.Sprintf("%s, %s, %s, %s, %s", patient.StreetAddress, patient.ZipCode, patient.CityName, patient.StateName, patient.CountryName)
-	_, err = conn.Exec(c, `
+
+	// The account and the profile are created together
+	tx, err := conn.Begin(c)
+	if err != nil {
+		apierrors.Abort(c, apierrors.CodeInternal, "Internal server error")
+		return
+	}
+	defer tx.Rollback(c)
+
+	language := emails.LanguageFromRequest(patient.PreferredLanguage, c.GetHeader("Accept-Language"))
+	userId, err := insertAccount(c, tx, patient.Email, hashedPassword, "patient", language)
+	if err != nil {
+		apierrors.Internal(c, "Error creating account", err)
+		return
+	}
+
+	_, err = tx.Exec(c, `
     INSERT INTO patient_info (
         patient_id, 
+        user_id, 
         username, 
         first_name, 
         last_name, 
         age, 
         sex, 
-        hashed_password, 
-        salt, 
         create_at, 
         update_at, 
         patient_bio, 
@@ -143,17 +143,15 @@ func RegisterPatient(c *gin.Context, pool *pgxpool.Pool) {
         location
     ) 
     VALUES (
-        uuid_generate_v4(), 
-        $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, 
-        $11, $12, $13, $14, $15, $16, $17, $18, $19
+        $1, $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, 
+        $11, $12, $13, $14, $15, $16, $17, $18
     )`, 
+    userId, 
     patient.Username, 
     patient.FirstName, 
     patient.LastName, 
     age, 
     patient.Sex, 
-    hashedPassword, 
-    salt, 
     time.Now(), 
     time.Now(), 
     patient.PatientBio, 
@@ -168,99 +166,61 @@ func RegisterPatient(c *gin.Context, pool *pgxpool.Pool) {
     location,
 )
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		apierrors.Abort(c, apierrors.CodeInternal, "Internal server error")
 		return
 	}
 
-	verificationLink := validators.GenerateVerificationLink(patient.Email, c, pool)
+	verificationLink := validators.GenerateVerificationLink(patient.Email, tx)
 	if verificationLink == "" {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate verification link"})
+		apierrors.Abort(c, apierrors.CodeInternal, "Could not generate verification link")
 		return
 	}
 
-	// Sending the verification email
-	err = validators.SendVerificationEmail(patient.Email, verificationLink) 
+	// Queueing the verification email, it is only sent once the account is committed
+	err = validators.QueueVerificationEmail(c, tx, patient.Email, language, verificationLink)
 	if err != nil {
-		log.Printf("Failed to send verification email: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification email"})
+		apierrors.Internal(c, "Failed to queue verification email", err)
+		return
+	}
+
+	if err := tx.Commit(c); err != nil {
+		apierrors.Abort(c, apierrors.CodeInternal, "Internal server error")
 		return
 	}
 
 	// Respond to the user
 	c.JSON(http.StatusCreated, gin.H{
-		"success": "true",
+		"success": true,
 		"message": "Patient created successfully. Please check your email to verify your account.",
 	})
 
 }
 
 
-func patientToAuthUser(p *models.Patient) auth.User {
-	return auth.User{ID: p.Email}  
-}
-
+// LoginPatient is kept for older clients, it only accepts patient accounts
 func LoginPatient(c *gin.Context, pool *pgxpool.Pool) {
-	var loginReq models.LoginRequest
-
-	if err := c.ShouldBindJSON(&loginReq); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
-		return
-	}
-
-	// checking if the account is verified
-	var isVerified bool
-	ctx := context.Background()
-	err := pool.QueryRow(ctx, "SELECT is_verified FROM patient_info WHERE email = $1", loginReq.Email).Scan(&isVerified)
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "message": "Account Not Verified"})
-		return	
-	}
-
-	// if isVerified == false then tell him to verify his account.
-	if !isVerified {
-		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "message": "Account Not Verified, Please check your email to verify your account."})
-		return
-	}
-
-
-	// Fetching the patient from the database based on the email
-	var patient models.Patient
-	ctx = context.Background()
-	err = pool.QueryRow(ctx, "SELECT email, hashed_password FROM patient_info WHERE email = $1", loginReq.Email).Scan(
-	&patient.Email,
-	&patient.Password,
-	)
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "message": "Invalid email or password"})
-		return
-	}
-
-	// Comparing the stored hashed password, with the hashed version of the password that was received
-	err = bcrypt.CompareHashAndPassword([]byte(patient.Password), []byte(loginReq.Password))
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "message": "ssInvalid email or password"})
-		return
-	}
-
-	// generating a session token
-	user := patientToAuthUser(&patient)
-	token, err := auth.GenerateToken(user, "patient")
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
-		return
-	}
+	loginAccount(c, pool, "patient")
+}
 
-	// get user id 
-	var patientId string
-	err = pool.QueryRow(ctx, "SELECT patient_id FROM patient_info WHERE email = $1", loginReq.Email).Scan(
-		&patientId,
-	)
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "message": "Invalid email or password"})
+// UpdatePatientProfile applies a partial update to the authenticated patient's profile
+func UpdatePatientProfile(c *gin.Context, pool *pgxpool.Pool) {
+	var request models.PatientProfileUpdate
+	if !validators.BindJSON(c, &request) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"success": true, "token": token, "patient_id": patientId})
-
+	update := &profileUpdate{}
+	update.set("phone_number", "PhoneNumber", request.PhoneNumber, true)
+	update.set("first_name", "FirstName", request.FirstName, true)
+	update.set("last_name", "LastName", request.LastName, true)
+	update.setBirthDate(request.BirthDate)
+	update.set("street_address", "StreetAddress", request.StreetAddress, true)
+	update.set("city_name", "CityName", request.CityName, true)
+	update.set("state_name", "StateName", request.StateName, false)
+	update.set("zip_code", "ZipCode", request.ZipCode, true)
+	update.set("country_name", "CountryName", request.CountryName, true)
+	update.set("patient_bio", "PatientBio", request.PatientBio, false)
+	update.set("sex", "sex", request.Sex, true)
 
+	updateProfile(c, pool, "patient_info", "patient_id", request.Email, update)
 }
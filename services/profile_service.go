@@ -0,0 +1,178 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"tbibi_back_end_go/apierrors"
+	"tbibi_back_end_go/auth"
+	"tbibi_back_end_go/validators"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Same format as the location built at registration
+const locationExpression = "street_address || ', ' || zip_code || ', ' || city_name || ', ' || state_name || ', ' || country_name"
+
+type profileStatement struct {
+	sql  string
+	args []interface{}
+}
+
+// profileUpdate collects the columns a PATCH on a profile changes
+type profileUpdate struct {
+	columns []string
+	params  []interface{}
+	fields  []validators.FieldError
+	// run in the same transaction, before the profile row is updated
+	before []profileStatement
+}
+
+func (u *profileUpdate) param(value interface{}) string {
+	u.params = append(u.params, value)
+	return "$" + strconv.Itoa(len(u.params))
+}
+
+// set changes column when the request has the field, required columns cannot be emptied
+func (u *profileUpdate) set(column string, field string, value *string, required bool) {
+	if value == nil {
+		return
+	}
+	trimmed := strings.TrimSpace(*value)
+	if required && trimmed == "" {
+		u.fields = append(u.fields, validators.FieldError{Field: field, Rule: "required", Message: "is required"})
+		return
+	}
+	u.columns = append(u.columns, column+" = "+u.param(trimmed))
+}
+
+// setBirthDate changes the birth date and the age derived from it
+func (u *profileUpdate) setBirthDate(value *string) {
+	if value == nil {
+		return
+	}
+	u.set("birth_date", "BirthDate", value, true)
+	if birthDate, err := time.Parse("2006-01-02", *value); err == nil {
+		u.columns = append(u.columns, "age = "+u.param(time.Now().Year()-birthDate.Year()))
+	}
+}
+
+// updateProfile applies the update to the authenticated user's row of table. A new
+// email address has to be verified again: the account is marked unverified, its
+// sessions are revoked and an activation link is sent to the new address.
+func updateProfile(c *gin.Context, pool *pgxpool.Pool, table string, idColumn string, email *string, update *profileUpdate) {
+	if email != nil && strings.TrimSpace(*email) == "" {
+		update.fields = append(update.fields, validators.FieldError{Field: "Email", Rule: "required", Message: "is required"})
+	}
+	if len(update.fields) > 0 {
+		apierrors.Abort(c, apierrors.CodeValidationFailed, "Invalid request", gin.H{"fields": update.fields})
+		return
+	}
+
+	ctx := context.Background()
+	userID := auth.GetUserID(c)
+	account, err := findAccountByID(ctx, pool, userID)
+	if err == pgx.ErrNoRows {
+		apierrors.Abort(c, apierrors.CodeNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		apierrors.Internal(c, "Error loading account", err)
+		return
+	}
+
+	newEmail := ""
+	if email != nil && !strings.EqualFold(strings.TrimSpace(*email), account.Email) {
+		newEmail = strings.TrimSpace(*email)
+		exists, err := accountEmailExists(ctx, pool, newEmail)
+		if err != nil {
+			apierrors.Internal(c, "Error checking email", err)
+			return
+		}
+		if exists {
+			apierrors.Abort(c, apierrors.CodeEmailTaken, "Email already exists")
+			return
+		}
+		update.columns = append(update.columns, "email = "+update.param(newEmail))
+	}
+
+	if len(update.columns) == 0 {
+		apierrors.Abort(c, apierrors.CodeInvalidRequest, "Nothing to update")
+		return
+	}
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		apierrors.Internal(c, "Transaction Error", err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	for _, statement := range update.before {
+		if _, err := tx.Exec(ctx, statement.sql, statement.args...); err != nil {
+			apierrors.Internal(c, "Error updating profile", err)
+			return
+		}
+	}
+
+	query := "UPDATE " + table + " SET " + strings.Join(update.columns, ", ") + ", update_at = NOW() WHERE " + idColumn + " = " + update.param(userID)
+	tag, err := tx.Exec(ctx, query, update.params...)
+	if err != nil {
+		apierrors.Internal(c, "Error updating profile", err)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		apierrors.Abort(c, apierrors.CodeNotFound, "Profile not found")
+		return
+	}
+
+	// location is derived from the address, it is rebuilt from the updated row
+	_, err = tx.Exec(ctx, "UPDATE "+table+" SET location = "+locationExpression+" WHERE "+idColumn+" = $1", userID)
+	if err != nil {
+		apierrors.Internal(c, "Error updating location", err)
+		return
+	}
+
+	if newEmail != "" {
+		_, err = tx.Exec(ctx, "UPDATE users SET email = $1, is_verified = FALSE, updated_at = NOW() WHERE user_id = $2", newEmail, userID)
+		if err != nil {
+			apierrors.Internal(c, "Error updating account email", err)
+			return
+		}
+		_, err = tx.Exec(ctx, "DELETE FROM verification_tokens WHERE LOWER(email) = LOWER($1) AND type = $2", account.Email, validators.TokenTypeAccountValidation)
+		if err != nil {
+			apierrors.Internal(c, "Error deleting verification tokens", err)
+			return
+		}
+		_, err = tx.Exec(ctx, "UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL", userID)
+		if err != nil {
+			apierrors.Internal(c, "Error revoking sessions", err)
+			return
+		}
+
+		verificationLink := validators.GenerateVerificationLink(newEmail, tx)
+		if verificationLink == "" {
+			apierrors.Abort(c, apierrors.CodeInternal, "Could not generate verification link")
+			return
+		}
+		if err := validators.QueueVerificationEmail(ctx, tx, newEmail, account.PreferredLanguage, verificationLink); err != nil {
+			apierrors.Internal(c, "Failed to queue verification email", err)
+			return
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		apierrors.Internal(c, "Commit Error", err)
+		return
+	}
+
+	response := gin.H{"success": true, "verification_required": newEmail != "", "message": "Profile updated successfully"}
+	if newEmail != "" {
+		response["message"] = "Profile updated. Please check your new email address to verify your account, you have been logged out."
+	}
+	c.JSON(http.StatusOK, response)
+}
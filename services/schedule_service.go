@@ -0,0 +1,286 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"tbibi_back_end_go/apierrors"
+	"tbibi_back_end_go/auth"
+	"tbibi_back_end_go/models"
+	"tbibi_back_end_go/schedule"
+	"tbibi_back_end_go/validators"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+const scheduleTemplateColumns = `id::text, weekdays, to_char(start_time, 'HH24:MI'), to_char(end_time, 'HH24:MI'),
+	slot_minutes, break_minutes, time_zone, effective_from::text, effective_until::text`
+
+func scanScheduleTemplate(row pgx.Row) (models.ScheduleTemplate, error) {
+	var template models.ScheduleTemplate
+	var weekdays []int32
+	err := row.Scan(&template.ID, &weekdays, &template.StartTime, &template.EndTime, &template.SlotMinutes, &template.BreakMinutes,
+		&template.TimeZone, &template.EffectiveFrom, &template.EffectiveUntil)
+	template.Weekdays = []int{}
+	for _, weekday := range weekdays {
+		template.Weekdays = append(template.Weekdays, int(weekday))
+	}
+	return template, err
+}
+
+// validateScheduleTemplate checks what the binding tags cannot compare
+func validateScheduleTemplate(request models.ScheduleTemplateRequest) []validators.FieldError {
+	var fields []validators.FieldError
+	// HH:MM strings compare like the times they hold
+	if request.EndTime <= request.StartTime {
+		fields = append(fields, validators.FieldError{Field: "end_time", Rule: "gtfield", Message: "must be after start_time"})
+	}
+	if request.EffectiveUntil != nil && *request.EffectiveUntil < request.EffectiveFrom {
+		fields = append(fields, validators.FieldError{Field: "effective_until", Rule: "gtefield", Message: "must not be before effective_from"})
+	}
+	return fields
+}
+
+// regenerateSchedule updates the doctor's generated slots in the transaction and
+// commits it. It answers the request itself when it fails.
+func regenerateSchedule(c *gin.Context, ctx context.Context, tx pgx.Tx, doctorID string) (gin.H, bool) {
+	created, removed, err := schedule.Regenerate(ctx, tx, doctorID)
+	if err != nil {
+		apierrors.Internal(c, "Error generating slots", err)
+		return nil, false
+	}
+	if err := tx.Commit(ctx); err != nil {
+		apierrors.Internal(c, "Commit Error", err)
+		return nil, false
+	}
+	return gin.H{"created": created, "removed": removed}, true
+}
+
+// GetSchedule lists the authenticated doctor's schedule templates and days off
+func GetSchedule(c *gin.Context, pool *pgxpool.Pool) {
+	ctx := context.Background()
+	doctorID := auth.GetUserID(c)
+
+	rows, err := pool.Query(ctx, "SELECT "+scheduleTemplateColumns+" FROM schedule_templates WHERE doctor_id = $1 ORDER BY created_at", doctorID)
+	if err != nil {
+		apierrors.Internal(c, "Error fetching schedule templates", err)
+		return
+	}
+	templates := []models.ScheduleTemplate{}
+	for rows.Next() {
+		template, err := scanScheduleTemplate(rows)
+		if err != nil {
+			rows.Close()
+			apierrors.Internal(c, "Error scanning schedule template", err)
+			return
+		}
+		templates = append(templates, template)
+	}
+	rows.Close()
+
+	rows, err = pool.Query(ctx, `
+		SELECT id::text, exception_date::text, reason FROM schedule_exceptions
+		WHERE doctor_id = $1 AND exception_date >= CURRENT_DATE ORDER BY exception_date`, doctorID)
+	if err != nil {
+		apierrors.Internal(c, "Error fetching schedule exceptions", err)
+		return
+	}
+	defer rows.Close()
+	exceptions := []models.ScheduleException{}
+	for rows.Next() {
+		var exception models.ScheduleException
+		if err := rows.Scan(&exception.ID, &exception.Date, &exception.Reason); err != nil {
+			apierrors.Internal(c, "Error scanning schedule exception", err)
+			return
+		}
+		exceptions = append(exceptions, exception)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"templates": templates, "exceptions": exceptions})
+}
+
+// CreateScheduleTemplate adds a recurring schedule and generates its slots
+func CreateScheduleTemplate(c *gin.Context, pool *pgxpool.Pool) {
+	var request models.ScheduleTemplateRequest
+	if !validators.BindJSON(c, &request) {
+		return
+	}
+	if fields := validateScheduleTemplate(request); len(fields) > 0 {
+		apierrors.Abort(c, apierrors.CodeValidationFailed, "Invalid request", gin.H{"fields": fields})
+		return
+	}
+
+	ctx := context.Background()
+	doctorID := auth.GetUserID(c)
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		apierrors.Internal(c, "Transaction Error", err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	template, err := scanScheduleTemplate(tx.QueryRow(ctx, `
+		INSERT INTO schedule_templates (doctor_id, weekdays, start_time, end_time, slot_minutes, break_minutes, time_zone, effective_from, effective_until)
+		VALUES ($1, $2, $3, $4, $5, $6, COALESCE(NULLIF($7, ''), (SELECT time_zone FROM doctor_info WHERE doctor_id = $1)), $8, $9)
+		RETURNING `+scheduleTemplateColumns,
+		doctorID, request.Weekdays, request.StartTime, request.EndTime, request.SlotMinutes, request.BreakMinutes,
+		request.TimeZone, request.EffectiveFrom, request.EffectiveUntil))
+	if err != nil {
+		apierrors.Internal(c, "Error creating schedule template", err)
+		return
+	}
+
+	slots, ok := regenerateSchedule(c, ctx, tx, doctorID)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"template": template, "slots": slots})
+}
+
+// UpdateScheduleTemplate replaces a schedule template. Its free future slots
+// are regenerated, booked appointments stay as they are.
+func UpdateScheduleTemplate(c *gin.Context, pool *pgxpool.Pool) {
+	var request models.ScheduleTemplateRequest
+	if !validators.BindJSON(c, &request) {
+		return
+	}
+	if fields := validateScheduleTemplate(request); len(fields) > 0 {
+		apierrors.Abort(c, apierrors.CodeValidationFailed, "Invalid request", gin.H{"fields": fields})
+		return
+	}
+
+	ctx := context.Background()
+	doctorID := auth.GetUserID(c)
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		apierrors.Internal(c, "Transaction Error", err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	template, err := scanScheduleTemplate(tx.QueryRow(ctx, `
+		UPDATE schedule_templates SET weekdays = $1, start_time = $2, end_time = $3, slot_minutes = $4, break_minutes = $5,
+			time_zone = COALESCE(NULLIF($6, ''), (SELECT time_zone FROM doctor_info WHERE doctor_id = $10)), effective_from = $7, effective_until = $8, updated_at = NOW()
+		WHERE id::text = $9 AND doctor_id = $10
+		RETURNING `+scheduleTemplateColumns,
+		request.Weekdays, request.StartTime, request.EndTime, request.SlotMinutes, request.BreakMinutes,
+		request.TimeZone, request.EffectiveFrom, request.EffectiveUntil, c.Param("templateId"), doctorID))
+	if err == pgx.ErrNoRows {
+		apierrors.Abort(c, apierrors.CodeNotFound, "Schedule template not found")
+		return
+	}
+	if err != nil {
+		apierrors.Internal(c, "Error updating schedule template", err)
+		return
+	}
+
+	slots, ok := regenerateSchedule(c, ctx, tx, doctorID)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"template": template, "slots": slots})
+}
+
+// DeleteScheduleTemplate removes a schedule template with its free future slots
+func DeleteScheduleTemplate(c *gin.Context, pool *pgxpool.Pool) {
+	ctx := context.Background()
+	doctorID := auth.GetUserID(c)
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		apierrors.Internal(c, "Transaction Error", err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	// past slots are kept as plain availabilities by the foreign key
+	_, err = tx.Exec(ctx, `
+		DELETE FROM availabilities WHERE doctor_id = $1 AND schedule_template_id::text = $2 AND availability_start > $3`,
+		doctorID, c.Param("templateId"), time.Now().UTC())
+	if err != nil {
+		apierrors.Internal(c, "Error deleting generated slots", err)
+		return
+	}
+	tag, err := tx.Exec(ctx, "DELETE FROM schedule_templates WHERE id::text = $1 AND doctor_id = $2", c.Param("templateId"), doctorID)
+	if err != nil {
+		apierrors.Internal(c, "Error deleting schedule template", err)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		apierrors.Abort(c, apierrors.CodeNotFound, "Schedule template not found")
+		return
+	}
+
+	slots, ok := regenerateSchedule(c, ctx, tx, doctorID)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "slots": slots})
+}
+
+// CreateScheduleException marks a day off, the free slots generated for it are removed
+func CreateScheduleException(c *gin.Context, pool *pgxpool.Pool) {
+	var request models.ScheduleExceptionRequest
+	if !validators.BindJSON(c, &request) {
+		return
+	}
+
+	ctx := context.Background()
+	doctorID := auth.GetUserID(c)
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		apierrors.Internal(c, "Transaction Error", err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	exception := models.ScheduleException{Date: request.Date, Reason: request.Reason}
+	err = tx.QueryRow(ctx, `
+		INSERT INTO schedule_exceptions (doctor_id, exception_date, reason) VALUES ($1, $2, $3)
+		ON CONFLICT (doctor_id, exception_date) DO NOTHING
+		RETURNING id::text`,
+		doctorID, request.Date, request.Reason).Scan(&exception.ID)
+	if err == pgx.ErrNoRows {
+		apierrors.Abort(c, apierrors.CodeConflict, "This day is already marked as a day off")
+		return
+	}
+	if err != nil {
+		apierrors.Internal(c, "Error creating schedule exception", err)
+		return
+	}
+
+	slots, ok := regenerateSchedule(c, ctx, tx, doctorID)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"exception": exception, "slots": slots})
+}
+
+// DeleteScheduleException removes a day off, its slots are generated again
+func DeleteScheduleException(c *gin.Context, pool *pgxpool.Pool) {
+	ctx := context.Background()
+	doctorID := auth.GetUserID(c)
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		apierrors.Internal(c, "Transaction Error", err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, "DELETE FROM schedule_exceptions WHERE id::text = $1 AND doctor_id = $2", c.Param("exceptionId"), doctorID)
+	if err != nil {
+		apierrors.Internal(c, "Error deleting schedule exception", err)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		apierrors.Abort(c, apierrors.CodeNotFound, "Schedule exception not found")
+		return
+	}
+
+	slots, ok := regenerateSchedule(c, ctx, tx, doctorID)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "slots": slots})
+}
@@ -0,0 +1,225 @@
+package services
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"tbibi_back_end_go/apierrors"
+	"tbibi_back_end_go/auth"
+	"tbibi_back_end_go/validators"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+type Session struct {
+	SessionID  string    `json:"session_id"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// createSession stores a new refresh token for the user and returns it together with an access token bound to the session
+func createSession(c *gin.Context, pool *pgxpool.Pool, user auth.User) (string, string, error) {
+	refreshToken, tokenHash, err := auth.GenerateRefreshToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	var sessionID string
+	err = pool.QueryRow(context.Background(), `
+		INSERT INTO refresh_tokens (user_id, user_type, token_hash, user_agent, ip_address, created_at, last_used_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, NOW(), NOW(), $6)
+		RETURNING session_id`,
+		user.ID, user.Type, tokenHash, c.Request.UserAgent(), c.ClientIP(), time.Now().Add(auth.RefreshTokenTTL)).Scan(&sessionID)
+	if err != nil {
+		return "", "", err
+	}
+
+	accessToken, err := auth.GenerateToken(user, sessionID)
+	if err != nil {
+		return "", "", err
+	}
+	return accessToken, refreshToken, nil
+}
+
+// sessionUser loads the current verification state so refreshed tokens reflect it
+func sessionUser(ctx context.Context, pool *pgxpool.Pool, userID string, userType string) (auth.User, error) {
+	user := auth.User{ID: userID, Type: userType}
+	err := pool.QueryRow(ctx, "SELECT is_verified FROM users WHERE user_id = $1 AND user_type = $2", userID, userType).Scan(&user.Verified)
+	return user, err
+}
+
+// revokeAllSessions revokes every active session of a user
+func revokeAllSessions(ctx context.Context, pool *pgxpool.Pool, userID string) error {
+	_, err := pool.Exec(ctx, "UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL", userID)
+	return err
+}
+
+// RefreshSession exchanges a refresh token for a new access token and rotates the refresh token
+func RefreshSession(c *gin.Context, pool *pgxpool.Pool) {
+	var req refreshRequest
+	if !validators.BindJSON(c, &req) {
+		return
+	}
+	tokenHash := auth.HashRefreshToken(req.RefreshToken)
+	ctx := context.Background()
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		apierrors.Internal(c, "Transaction Error", err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	var sessionID, userID, userType string
+	var expiresAt time.Time
+	var revokedAt *time.Time
+	err = tx.QueryRow(ctx, `
+		SELECT session_id, user_id, user_type, expires_at, revoked_at
+		FROM refresh_tokens WHERE token_hash = $1
+		FOR UPDATE`, tokenHash).Scan(&sessionID, &userID, &userType, &expiresAt, &revokedAt)
+	if err == pgx.ErrNoRows {
+		// A token that was already rotated is being replayed, the session is considered stolen
+		_, err = pool.Exec(ctx, "UPDATE refresh_tokens SET revoked_at = NOW() WHERE previous_token_hash = $1 AND revoked_at IS NULL", tokenHash)
+		if err != nil {
+			log.Println("Error revoking replayed session:", err)
+		}
+		apierrors.Abort(c, apierrors.CodeInvalidToken, "Invalid refresh token")
+		return
+	}
+	if err != nil {
+		apierrors.Internal(c, "Error fetching refresh token", err)
+		return
+	}
+
+	if revokedAt != nil || time.Now().After(expiresAt) {
+		apierrors.Abort(c, apierrors.CodeSessionExpired, "Session expired, please log in again")
+		return
+	}
+
+	newRefreshToken, newTokenHash, err := auth.GenerateRefreshToken()
+	if err != nil {
+		apierrors.Abort(c, apierrors.CodeInternal, "Could not generate token")
+		return
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE refresh_tokens
+		SET token_hash = $1, previous_token_hash = $2, last_used_at = NOW(), expires_at = $3, user_agent = $4, ip_address = $5
+		WHERE session_id = $6`,
+		newTokenHash, tokenHash, time.Now().Add(auth.RefreshTokenTTL), c.Request.UserAgent(), c.ClientIP(), sessionID)
+	if err != nil {
+		apierrors.Internal(c, "Error rotating refresh token", err)
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		apierrors.Internal(c, "Commit Error", err)
+		return
+	}
+
+	user, err := sessionUser(ctx, pool, userID, userType)
+	if err != nil {
+		log.Println("Error loading session user:", err)
+		apierrors.Abort(c, apierrors.CodeSessionExpired, "Session expired, please log in again")
+		return
+	}
+
+	accessToken, err := auth.GenerateToken(user, sessionID)
+	if err != nil {
+		apierrors.Abort(c, apierrors.CodeInternal, "Could not generate token")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "token": accessToken, "refresh_token": newRefreshToken})
+}
+
+// Logout revokes the session the refresh token belongs to
+func Logout(c *gin.Context, pool *pgxpool.Pool) {
+	var req refreshRequest
+	if !validators.BindJSON(c, &req) {
+		return
+	}
+
+	_, err := pool.Exec(context.Background(),
+		"UPDATE refresh_tokens SET revoked_at = NOW() WHERE token_hash = $1 AND revoked_at IS NULL",
+		auth.HashRefreshToken(req.RefreshToken))
+	if err != nil {
+		apierrors.Internal(c, "Error revoking session", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Logged out successfully"})
+}
+
+// ListSessions returns the active sessions of the authenticated user
+func ListSessions(c *gin.Context, pool *pgxpool.Pool) {
+	userID := auth.GetUserID(c)
+	currentSessionID := auth.GetSessionID(c)
+
+	rows, err := pool.Query(context.Background(), `
+		SELECT session_id, user_agent, ip_address, created_at, last_used_at, expires_at
+		FROM refresh_tokens
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		ORDER BY last_used_at DESC`, userID)
+	if err != nil {
+		apierrors.Internal(c, "Error listing sessions", err)
+		return
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var session Session
+		if err := rows.Scan(&session.SessionID, &session.UserAgent, &session.IPAddress, &session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt); err != nil {
+			apierrors.Internal(c, "Row Scan Error", err)
+			return
+		}
+		session.Current = session.SessionID == currentSessionID
+		sessions = append(sessions, session)
+	}
+
+	c.JSON(http.StatusOK, sessions)
+}
+
+// RevokeSession revokes one of the authenticated user's sessions, e.g. the one of a lost device
+func RevokeSession(c *gin.Context, pool *pgxpool.Pool) {
+	sessionID := c.Param("sessionId")
+
+	tag, err := pool.Exec(context.Background(),
+		"UPDATE refresh_tokens SET revoked_at = NOW() WHERE session_id::text = $1 AND user_id = $2 AND revoked_at IS NULL",
+		sessionID, auth.GetUserID(c))
+	if err != nil {
+		apierrors.Internal(c, "Error revoking session", err)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		apierrors.Abort(c, apierrors.CodeNotFound, "Session not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Session revoked"})
+}
+
+// RevokeOtherSessions revokes every session of the authenticated user except the current one
+func RevokeOtherSessions(c *gin.Context, pool *pgxpool.Pool) {
+	_, err := pool.Exec(context.Background(),
+		"UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND session_id::text <> $2 AND revoked_at IS NULL",
+		auth.GetUserID(c), auth.GetSessionID(c))
+	if err != nil {
+		apierrors.Internal(c, "Error revoking sessions", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Other sessions revoked"})
+}
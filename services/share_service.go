@@ -6,7 +6,10 @@ import (
 	"context"
 	"log"
 	"net/http"
+	"tbibi_back_end_go/apierrors"
+	"tbibi_back_end_go/auth"
 	"tbibi_back_end_go/models"
+	"tbibi_back_end_go/validators"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -15,16 +18,20 @@ import (
 
 
 type ShareRequest struct {
-    SharedWithID string   `json:"sharedWithID"`
-    ItemIDs    []string `json:"itemIDs"`
+    SharedWithID string   `json:"sharedWithID" binding:"required,uuid"`
+    ItemIDs    []string `json:"itemIDs" binding:"required,min=1,max=100,dive,uuid"`
     UserID     string   `json:"userID"`
     UserType     string   `json:"userType"`
 }
 // i already have a GetAllDoctors function in doctor_service.go but this one is going to be different in the futur. it will be used to get all the doctors that the patient has followed (or have gave him access to his files. don't know yet which one i will sue) I will include getting the doctors photo as well.
 func ListDoctors(c *gin.Context, db *pgxpool.Pool) {
-    rows, err := db.Query(context.Background(), "SELECT doctor_id, first_name , last_name, specialty FROM users WHERE user_type = 'doctor'")
+    rows, err := db.Query(context.Background(), `
+        SELECT d.doctor_id, d.first_name, d.last_name, d.specialty
+        FROM users u
+        JOIN doctor_info d ON d.user_id = u.user_id
+        WHERE u.user_type = 'doctor' AND u.is_verified AND d.license_status = $1`, models.LicenseStatusApproved)
     if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not retrieve doctors list"})
+        apierrors.Abort(c, apierrors.CodeInternal, "Could not retrieve doctors list")
         return
     }
 
@@ -46,20 +53,36 @@ func ShareItem(c *gin.Context, db *pgxpool.Pool) {
     var req ShareRequest
 
     // Bind JSON body to the ShareRequest struct
-    if err := c.BindJSON(&req); err != nil {
-        log.Printf("Error binding JSON: %v\n", err)
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+    if !validators.BindJSON(c, &req) {
         return
     }
+    req.UserID = auth.GetUserID(c)
+    req.UserType = auth.GetUserType(c)
+
+    ctx := context.Background()
+    tx, err := db.Begin(ctx)
+    if err != nil {
+        apierrors.Internal(c, "Transaction Error", err)
+        return
+    }
+    defer tx.Rollback(ctx)
 
-	// if req.UserType == "patient" && req.RecipientType != "doctor" {
-    //     c.JSON(http.StatusBadRequest, gin.H{"error": "Patients can only share with doctors"})
-    //     return
-    // } else if req.UserType == "doctor" && req.RecipientType != "doctor" {
-    //     c.JSON(http.StatusBadRequest, gin.H{"error": "Doctors can only share with other doctors"})
-    //     return
-    // }
     // Iterate over each itemID and share it with the specified user
+    for _, itemID := range req.ItemIDs {
+        // Only the owner of an item can share it
+        owned, err := auth.IsFolderFileOwner(ctx, tx, itemID, req.UserID)
+        if err != nil {
+            apierrors.Internal(c, "Unable to check the owner of item "+itemID, err)
+            return
+        }
+        if !owned {
+            auth.AbortForbidden(c)
+            return
+        }
+    }
+
+    // Items are shared all together or not at all, with the notification of the recipient
+    sharedNames := []string{}
     for _, itemID := range req.ItemIDs {
         sharedItem := models.SharedItem{
             ItemID:    itemID,
@@ -69,13 +92,24 @@ func ShareItem(c *gin.Context, db *pgxpool.Pool) {
         }
 
         // Prepare SQL query to insert the new shared item record
-        sql := `INSERT INTO shared_items (item_id, shared_by_id, shared_with_id, shared_at) VALUES ($1, $2, $3, $4)`
-        _, err := db.Exec(context.Background(), sql, sharedItem.ItemID, sharedItem.SharedBy, sharedItem.SharedWith, sharedItem.SharedAt) 
-        if err != nil {  
-            log.Printf("Unable to insert the new shared item record into the database: %v\n", err)
-            // Continue attempting to share the remaining items even if one fails
-            continue
+        sql := `INSERT INTO shared_items (item_id, shared_by_id, shared_with_id, shared_at) VALUES ($1, $2, $3, $4)
+            RETURNING (SELECT name FROM folder_file_info WHERE id = item_id)`
+        var name string
+        err := tx.QueryRow(ctx, sql, sharedItem.ItemID, sharedItem.SharedBy, sharedItem.SharedWith, sharedItem.SharedAt).Scan(&name)
+        if err != nil {
+            apierrors.Internal(c, "Unable to insert the shared item record", err)
+            return
         }
+        sharedNames = append(sharedNames, name)
+    }
+
+    if err := notifyItemsShared(ctx, tx, req.UserID, req.SharedWithID, sharedNames); err != nil {
+        apierrors.Internal(c, "Error queueing share notification", err)
+        return
+    }
+    if err := tx.Commit(ctx); err != nil {
+        apierrors.Internal(c, "Commit Error", err)
+        return
     }
 
     // Respond with success message
@@ -84,7 +118,7 @@ func ShareItem(c *gin.Context, db *pgxpool.Pool) {
 
 // Retrieve items shared with the user
 func GetSharedWithMe(c *gin.Context, db *pgxpool.Pool) {
-	userID := c.Query("userId")
+	userID := auth.GetUserID(c)
 	var items []models.FileFolder
 	log.Println("userID", userID)
 	// SQL query to retrieve the items shared with the user
@@ -106,8 +140,7 @@ func GetSharedWithMe(c *gin.Context, db *pgxpool.Pool) {
 
 	rows, err := db.Query(context.Background(), sql, userID)	
 	if err != nil {	
-		log.Printf("Unable to execute the select query: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve shared items"})
+		apierrors.Internal(c, "Unable to execute the select query", err)
 		return 
 	}
 
@@ -144,7 +177,7 @@ func GetSharedWithMe(c *gin.Context, db *pgxpool.Pool) {
 
 // Retrieve items shared by the user
 func GetSharedByMe(c *gin.Context, db *pgxpool.Pool) {
-	userID := c.Query("userId")
+	userID := auth.GetUserID(c)
 	var items []models.FileFolder
 	log.Println("userID", userID)
 	// SQL query to retrieve the items shared with the user
@@ -166,8 +199,7 @@ func GetSharedByMe(c *gin.Context, db *pgxpool.Pool) {
 
 	rows, err := db.Query(context.Background(), sql, userID)	
 	if err != nil {	
-		log.Printf("Unable to execute the select query: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve shared items"})
+		apierrors.Internal(c, "Unable to execute the select query", err)
 		return 
 	}
 
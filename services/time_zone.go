@@ -0,0 +1,72 @@
+package services
+
+import (
+	"context"
+	"tbibi_back_end_go/apierrors"
+	"tbibi_back_end_go/auth"
+	"tbibi_back_end_go/validators"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requestedLocation returns the time zone the caller asked for with the timeZone
+// query parameter, nil when it asked for none. It answers the request itself
+// when the zone is unknown.
+func requestedLocation(c *gin.Context) (*time.Location, bool) {
+	name := c.Query("timeZone")
+	if name == "" {
+		// older clients of GET /api/v1/reservations
+		name = c.Query("timezone")
+	}
+	if name == "" {
+		return nil, true
+	}
+	location, err := time.LoadLocation(name)
+	if err != nil || name == "Local" {
+		apierrors.Abort(c, apierrors.CodeValidationFailed, "Invalid request", gin.H{"fields": []validators.FieldError{
+			{Field: "timeZone", Rule: "timezone", Message: "must be an IANA time zone such as Europe/Berlin"},
+		}})
+		return nil, false
+	}
+	return location, true
+}
+
+// zoneResolver converts the times of slots and appointments to the zone the
+// caller asked for, or else to the practice zone of their doctor
+type zoneResolver struct {
+	requested *time.Location
+	practices map[string]*time.Location
+}
+
+func newZoneResolver(requested *time.Location) *zoneResolver {
+	return &zoneResolver{requested: requested, practices: map[string]*time.Location{}}
+}
+
+func (z *zoneResolver) location(practiceZone string) *time.Location {
+	if z.requested != nil {
+		return z.requested
+	}
+	if location, ok := z.practices[practiceZone]; ok {
+		return location
+	}
+	location, err := time.LoadLocation(practiceZone)
+	if err != nil {
+		location = time.UTC
+	}
+	z.practices[practiceZone] = location
+	return location
+}
+
+func (z *zoneResolver) in(t time.Time, practiceZone string) time.Time {
+	return t.In(z.location(practiceZone))
+}
+
+// practiceLocation returns the time zone of the doctor's practice, UTC when it cannot be loaded
+func practiceLocation(ctx context.Context, db auth.Querier, doctorID string) *time.Location {
+	var name string
+	if err := db.QueryRow(ctx, "SELECT time_zone FROM doctor_info WHERE doctor_id::text = $1", doctorID).Scan(&name); err != nil {
+		return time.UTC
+	}
+	return newZoneResolver(nil).location(name)
+}
@@ -0,0 +1,472 @@
+package services
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"tbibi_back_end_go/apierrors"
+	"tbibi_back_end_go/auth"
+	"tbibi_back_end_go/validators"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// twoFactorState is the TOTP configuration of an account
+type twoFactorState struct {
+	Secret   string
+	Enabled  bool
+	Required bool
+}
+
+// execer is satisfied by *pgxpool.Pool and pgx.Tx
+type execer interface {
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+}
+
+type twoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required,max=32"`
+}
+
+func loadTwoFactorState(ctx context.Context, db auth.Querier, userID string) (twoFactorState, error) {
+	var state twoFactorState
+	err := db.QueryRow(ctx,
+		"SELECT COALESCE(totp_secret, ''), totp_enabled, totp_required FROM users WHERE user_id::text = $1",
+		userID).Scan(&state.Secret, &state.Enabled, &state.Required)
+	return state, err
+}
+
+// respondMFARequired answers the password step of a login for an account that needs a second factor
+func respondMFARequired(c *gin.Context, account *Account, state twoFactorState) {
+	mfaToken, err := auth.GenerateMFAToken(auth.User{ID: account.UserID, Type: account.UserType, Verified: account.IsVerified})
+	if err != nil {
+		apierrors.Internal(c, "Error generating mfa token", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":            false,
+		"mfa_required":       true,
+		"mfa_setup_required": !state.Enabled,
+		"mfa_token":          mfaToken,
+		"role":               account.UserType,
+	})
+}
+
+// useTOTPCode accepts a TOTP code at most once, a code that was already used is refused even while it is still valid
+func useTOTPCode(ctx context.Context, pool *pgxpool.Pool, userID string, secret string, code string) (bool, error) {
+	step, ok := auth.ValidateTOTP(secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	tag, err := pool.Exec(ctx, `
+		UPDATE users SET totp_last_step = $1
+		WHERE user_id::text = $2 AND (totp_last_step IS NULL OR totp_last_step < $1)`,
+		step, userID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// useRecoveryCode consumes one of the user's unused recovery codes
+func useRecoveryCode(ctx context.Context, db execer, userID string, code string) (bool, error) {
+	tag, err := db.Exec(ctx,
+		"UPDATE recovery_codes SET used_at = NOW() WHERE user_id::text = $1 AND code_hash = $2 AND used_at IS NULL",
+		userID, auth.HashRecoveryCode(code))
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// verifySecondFactor checks a TOTP code, or a recovery code when allowed, under
+// the same lockout as passwords. It answers the request itself when the code is refused.
+func verifySecondFactor(c *gin.Context, pool *pgxpool.Pool, account *Account, secret string, code string, allowRecovery bool) bool {
+	ctx := context.Background()
+
+	remaining, err := loginLockout(c, pool, account.Email)
+	if err != nil {
+		apierrors.Internal(c, "Error checking login lockout", err)
+		return false
+	}
+	if remaining > 0 {
+		recordLoginAttempt(c, pool, account.Email, account.UserID, loginFailureLocked)
+		respondLoginLocked(c, remaining)
+		return false
+	}
+
+	ok, err := useTOTPCode(ctx, pool, account.UserID, secret, code)
+	if err == nil && !ok && allowRecovery {
+		ok, err = useRecoveryCode(ctx, pool, account.UserID, code)
+	}
+	if err != nil {
+		apierrors.Internal(c, "Error checking 2FA code", err)
+		return false
+	}
+	if !ok {
+		recordLoginAttempt(c, pool, account.Email, account.UserID, loginFailureWrongMFACode)
+		apierrors.Abort(c, apierrors.CodeInvalidMFACode, "Invalid authentication code")
+		return false
+	}
+	return true
+}
+
+// replaceRecoveryCodes discards the user's recovery codes and returns a fresh set, only their hashes are stored
+func replaceRecoveryCodes(ctx context.Context, tx pgx.Tx, userID string) ([]string, error) {
+	codes, err := auth.GenerateRecoveryCodes(auth.RecoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM recovery_codes WHERE user_id::text = $1", userID); err != nil {
+		return nil, err
+	}
+	for _, code := range codes {
+		_, err := tx.Exec(ctx, "INSERT INTO recovery_codes (user_id, code_hash, created_at) VALUES ($1, $2, NOW())", userID, auth.HashRecoveryCode(code))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+// currentAccount loads the account of the authenticated user, it answers the request itself on failure
+func currentAccount(c *gin.Context, pool *pgxpool.Pool) (*Account, twoFactorState, bool) {
+	ctx := context.Background()
+	account, err := findAccountByID(ctx, pool, auth.GetUserID(c))
+	if err == pgx.ErrNoRows {
+		auth.AbortUnauthorized(c)
+		return nil, twoFactorState{}, false
+	}
+	if err != nil {
+		apierrors.Internal(c, "Error fetching account", err)
+		return nil, twoFactorState{}, false
+	}
+	state, err := loadTwoFactorState(ctx, pool, account.UserID)
+	if err != nil {
+		apierrors.Internal(c, "Error fetching 2FA state", err)
+		return nil, twoFactorState{}, false
+	}
+	return account, state, true
+}
+
+// GetTwoFactorStatus tells the user whether 2FA is on, required, and how many recovery codes are left
+func GetTwoFactorStatus(c *gin.Context, pool *pgxpool.Pool) {
+	account, state, ok := currentAccount(c, pool)
+	if !ok {
+		return
+	}
+
+	var remaining int
+	err := pool.QueryRow(context.Background(),
+		"SELECT COUNT(*) FROM recovery_codes WHERE user_id = $1 AND used_at IS NULL", account.UserID).Scan(&remaining)
+	if err != nil {
+		apierrors.Internal(c, "Error counting recovery codes", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"enabled": state.Enabled, "required": state.Required, "recovery_codes_remaining": remaining})
+}
+
+// SetupTwoFactor starts the enrollment by generating a new secret, 2FA is only
+// turned on once ConfirmTwoFactor receives a code generated from it. The
+// password is checked so that a stolen token cannot enroll another authenticator.
+func SetupTwoFactor(c *gin.Context, pool *pgxpool.Pool) {
+	var req struct {
+		Password string `json:"password" binding:"required"`
+	}
+	if !validators.BindJSON(c, &req) {
+		return
+	}
+
+	account, state, ok := currentAccount(c, pool)
+	if !ok {
+		return
+	}
+	if state.Enabled {
+		apierrors.Abort(c, apierrors.CodeConflict, "2FA is already enabled")
+		return
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(account.HashedPassword), []byte(req.Password)); err != nil {
+		apierrors.Abort(c, apierrors.CodeInvalidCredentials, "Invalid password")
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		apierrors.Abort(c, apierrors.CodeInternal, "Internal Server Error")
+		return
+	}
+	_, err = pool.Exec(context.Background(),
+		"UPDATE users SET totp_secret = $1, totp_last_step = NULL, updated_at = NOW() WHERE user_id = $2", secret, account.UserID)
+	if err != nil {
+		apierrors.Internal(c, "Error storing 2FA secret", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"secret": secret, "otpauth_uri": auth.TOTPAuthURI(account.Email, secret)})
+}
+
+// ConfirmTwoFactor turns 2FA on once the user proves their authenticator works
+// and knows the password, and returns the recovery codes. When called with the
+// mfa token of a login that was waiting for enrollment, it also completes that login.
+func ConfirmTwoFactor(c *gin.Context, pool *pgxpool.Pool) {
+	var req struct {
+		Password string `json:"password" binding:"required"`
+		Code     string `json:"code" binding:"required,max=32"`
+	}
+	if !validators.BindJSON(c, &req) {
+		return
+	}
+
+	account, state, ok := currentAccount(c, pool)
+	if !ok {
+		return
+	}
+	if state.Enabled {
+		apierrors.Abort(c, apierrors.CodeConflict, "2FA is already enabled")
+		return
+	}
+	if state.Secret == "" {
+		apierrors.Abort(c, apierrors.CodeInvalidRequest, "2FA setup has not been started")
+		return
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(account.HashedPassword), []byte(req.Password)); err != nil {
+		apierrors.Abort(c, apierrors.CodeInvalidCredentials, "Invalid password")
+		return
+	}
+	if !verifySecondFactor(c, pool, account, state.Secret, req.Code, false) {
+		return
+	}
+
+	ctx := context.Background()
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		apierrors.Internal(c, "Transaction Error", err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, "UPDATE users SET totp_enabled = TRUE, updated_at = NOW() WHERE user_id = $1", account.UserID)
+	if err != nil {
+		apierrors.Internal(c, "Error enabling 2FA", err)
+		return
+	}
+	codes, err := replaceRecoveryCodes(ctx, tx, account.UserID)
+	if err != nil {
+		apierrors.Internal(c, "Error storing recovery codes", err)
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		apierrors.Internal(c, "Commit Error", err)
+		return
+	}
+
+	if claims := auth.GetClaims(c); claims != nil && claims.Purpose == auth.PurposeMFA {
+		completeLogin(c, pool, account, gin.H{"recovery_codes": codes})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "recovery_codes": codes})
+}
+
+// VerifyTwoFactor is the second step of a login, it exchanges the mfa token and a TOTP or recovery code for a session
+func VerifyTwoFactor(c *gin.Context, pool *pgxpool.Pool) {
+	var req struct {
+		MFAToken string `json:"mfa_token" binding:"required"`
+		Code     string `json:"code" binding:"required,max=32"`
+	}
+	if !validators.BindJSON(c, &req) {
+		return
+	}
+
+	claims, err := auth.ParseMFAToken(req.MFAToken)
+	if err != nil {
+		apierrors.Abort(c, apierrors.CodeInvalidToken, "Invalid or expired token")
+		return
+	}
+
+	ctx := context.Background()
+	account, err := findAccountByID(ctx, pool, claims.Subject)
+	if err == pgx.ErrNoRows {
+		apierrors.Abort(c, apierrors.CodeInvalidToken, "Invalid or expired token")
+		return
+	}
+	if err != nil {
+		apierrors.Internal(c, "Error fetching account", err)
+		return
+	}
+	state, err := loadTwoFactorState(ctx, pool, account.UserID)
+	if err != nil {
+		apierrors.Internal(c, "Error fetching 2FA state", err)
+		return
+	}
+	if !state.Enabled {
+		apierrors.Abort(c, apierrors.CodeInvalidRequest, "2FA is not set up for this account")
+		return
+	}
+
+	if !verifySecondFactor(c, pool, account, state.Secret, req.Code, true) {
+		return
+	}
+	completeLogin(c, pool, account, nil)
+}
+
+// DisableTwoFactor turns 2FA off after checking the password and a current code, unless an admin requires it
+func DisableTwoFactor(c *gin.Context, pool *pgxpool.Pool) {
+	var req struct {
+		Password string `json:"password" binding:"required"`
+		Code     string `json:"code" binding:"required,max=32"`
+	}
+	if !validators.BindJSON(c, &req) {
+		return
+	}
+
+	account, state, ok := currentAccount(c, pool)
+	if !ok {
+		return
+	}
+	if !state.Enabled {
+		apierrors.Abort(c, apierrors.CodeInvalidRequest, "2FA is not enabled")
+		return
+	}
+	if state.Required {
+		apierrors.Abort(c, apierrors.CodeForbidden, "2FA is required for your account")
+		return
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(account.HashedPassword), []byte(req.Password)); err != nil {
+		apierrors.Abort(c, apierrors.CodeInvalidCredentials, "Invalid password")
+		return
+	}
+	if !verifySecondFactor(c, pool, account, state.Secret, req.Code, true) {
+		return
+	}
+
+	if err := clearTwoFactor(context.Background(), pool, account.UserID); err != nil {
+		apierrors.Internal(c, "Error disabling 2FA", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes, for instance after they ran out
+func RegenerateRecoveryCodes(c *gin.Context, pool *pgxpool.Pool) {
+	var req twoFactorCodeRequest
+	if !validators.BindJSON(c, &req) {
+		return
+	}
+
+	account, state, ok := currentAccount(c, pool)
+	if !ok {
+		return
+	}
+	if !state.Enabled {
+		apierrors.Abort(c, apierrors.CodeInvalidRequest, "2FA is not enabled")
+		return
+	}
+	if !verifySecondFactor(c, pool, account, state.Secret, req.Code, false) {
+		return
+	}
+
+	ctx := context.Background()
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		apierrors.Internal(c, "Transaction Error", err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	codes, err := replaceRecoveryCodes(ctx, tx, account.UserID)
+	if err != nil {
+		apierrors.Internal(c, "Error storing recovery codes", err)
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		apierrors.Internal(c, "Commit Error", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "recovery_codes": codes})
+}
+
+// clearTwoFactor removes the TOTP secret and recovery codes of a user
+func clearTwoFactor(ctx context.Context, pool *pgxpool.Pool, userID string) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+		UPDATE users SET totp_secret = NULL, totp_enabled = FALSE, totp_last_step = NULL, updated_at = NOW()
+		WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// RequireTwoFactor lets an admin force an account, typically a doctor's, to use
+// 2FA from its next login on. Its sessions are ended so that none opened with
+// the password alone stays usable.
+func RequireTwoFactor(c *gin.Context, pool *pgxpool.Pool) {
+	var req struct {
+		Required *bool `json:"required" binding:"required"`
+	}
+	if !validators.BindJSON(c, &req) {
+		return
+	}
+
+	tag, err := pool.Exec(context.Background(),
+		"UPDATE users SET totp_required = $1, updated_at = NOW() WHERE user_id::text = $2", *req.Required, c.Param("userId"))
+	if err != nil {
+		apierrors.Internal(c, "Error updating 2FA requirement", err)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		apierrors.Abort(c, apierrors.CodeNotFound, "User not found")
+		return
+	}
+	if *req.Required {
+		if err := revokeAllSessions(context.Background(), pool, c.Param("userId")); err != nil {
+			apierrors.Internal(c, "Error revoking sessions", err)
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "required": *req.Required})
+}
+
+// ResetTwoFactor lets an admin clear the 2FA of a user who lost their device
+// and their recovery codes. All sessions of the user are ended.
+func ResetTwoFactor(c *gin.Context, pool *pgxpool.Pool) {
+	ctx := context.Background()
+	account, err := findAccountByID(ctx, pool, c.Param("userId"))
+	if err == pgx.ErrNoRows {
+		apierrors.Abort(c, apierrors.CodeNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		apierrors.Internal(c, "Error fetching account", err)
+		return
+	}
+
+	if err := clearTwoFactor(ctx, pool, account.UserID); err != nil {
+		apierrors.Internal(c, "Error resetting 2FA", err)
+		return
+	}
+	if err := revokeAllSessions(ctx, pool, account.UserID); err != nil {
+		apierrors.Internal(c, "Error revoking sessions", err)
+		return
+	}
+
+	log.Printf("Admin %s reset 2FA of user %s", auth.GetUserID(c), account.UserID)
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
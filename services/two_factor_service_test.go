@@ -0,0 +1,61 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"tbibi_back_end_go/auth"
+	"testing"
+
+	"github.com/jackc/pgconn"
+)
+
+// fakeRecoveryCodes plays the part of the recovery_codes table for the UPDATE of useRecoveryCode
+type fakeRecoveryCodes struct {
+	unused map[string]bool // code hash to unused
+}
+
+func (f *fakeRecoveryCodes) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	if !strings.Contains(sql, "UPDATE recovery_codes") {
+		return nil, fmt.Errorf("unexpected statement: %s", sql)
+	}
+	hash := args[1].(string)
+	if !f.unused[hash] {
+		return pgconn.CommandTag("UPDATE 0"), nil
+	}
+	f.unused[hash] = false
+	return pgconn.CommandTag("UPDATE 1"), nil
+}
+
+func TestUseRecoveryCodeOnce(t *testing.T) {
+	codes, err := auth.GenerateRecoveryCodes(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &fakeRecoveryCodes{unused: map[string]bool{}}
+	for _, code := range codes {
+		store.unused[auth.HashRecoveryCode(code)] = true
+	}
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		code string
+		ok   bool
+	}{
+		{"unused code", codes[0], true},
+		{"reused code", codes[0], false},
+		{"reused code typed differently", strings.ToLower(strings.ReplaceAll(codes[0], "-", "")), false},
+		{"unknown code", "AAAA-BBBB-CCCC-DDDD", false},
+		{"other unused code", codes[1], true},
+	}
+	for _, test := range tests {
+		ok, err := useRecoveryCode(ctx, store, "user", test.code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != test.ok {
+			t.Errorf("%s: accepted = %v, want %v", test.name, ok, test.ok)
+		}
+	}
+}
@@ -1,15 +1,23 @@
 package services
 
 import (
+	"context"
 	"encoding/json"
 	"log"
 	"net/http"
+	"sync"
+	"tbibi_back_end_go/apierrors"
+	"tbibi_back_end_go/auth"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"github.com/jackc/pgx/v4/pgxpool"
 )
 
-var clients = make(map[string]*Client) // map of clients
+// clients maps user IDs to their connection, every pump goroutine uses it so
+// it is only accessed with clientsMu held
+var clients = make(map[string]*Client)
+var clientsMu sync.RWMutex
 var upgrader = websocket.Upgrader{
     ReadBufferSize:  1024,
     WriteBufferSize: 1024,
@@ -22,6 +30,7 @@ type Client struct {
     userID   string
     conn     *websocket.Conn
     send     chan []byte
+    pool     *pgxpool.Pool
 }
 type Message struct {
     ChatID    string    `json:"chat_id"`
@@ -30,18 +39,21 @@ type Message struct {
     Content   string `json:"content"`
 }
 
-func ServeWs(c *gin.Context) {
-    userID := c.Query("userId") 
+func ServeWs(c *gin.Context, pool *pgxpool.Pool) {
+    userID := auth.GetUserID(c)
     log.Printf("Attempting to serve websocket for user %s", userID)
     conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
     if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "WebSocket upgrade failed: " + err.Error()})
+        // the upgrader already answered the client with an error status
+        log.Printf("[%s] WebSocket upgrade failed: %v", apierrors.GetRequestID(c), err)
         return
     }
-    client := &Client{userID: userID, conn: conn, send: make(chan []byte)}
+    client := &Client{userID: userID, conn: conn, send: make(chan []byte), pool: pool}
+    clientsMu.Lock()
     clients[userID] = client
-    log.Printf("User %s connected. Total clients: %d", userID, len(clients))
-    log.Printf("Current clients: %+v", clients)
+    total := len(clients)
+    clientsMu.Unlock()
+    log.Printf("User %s connected. Total clients: %d", userID, total)
 
 
     go client.writePump()
@@ -53,8 +65,14 @@ func (c *Client) readPump() {
         log.Printf("Closing connection for user %s", c.userID)
 
         c.conn.Close()
-        delete(clients, c.userID)
-        log.Printf("User %s disconnected. Total clients: %d", c.userID, len(clients))
+        clientsMu.Lock()
+        // the user may have reconnected in the meantime
+        if clients[c.userID] == c {
+            delete(clients, c.userID)
+        }
+        total := len(clients)
+        clientsMu.Unlock()
+        log.Printf("User %s disconnected. Total clients: %d", c.userID, total)
     }()
     for {
         _, message, err := c.conn.ReadMessage()
@@ -69,8 +87,21 @@ func (c *Client) readPump() {
             log.Printf("error: %v", err)
             continue
         }
-        
-        if recipient, ok := clients[msg.RecipientID]; ok {
+        // the sender is whoever owns this connection, whatever the message says
+        msg.SenderID = c.userID
+        if !c.canRelay(msg) {
+            continue
+        }
+        message, err = json.Marshal(msg)
+        if err != nil {
+            log.Printf("error: %v", err)
+            continue
+        }
+
+        clientsMu.RLock()
+        recipient, ok := clients[msg.RecipientID]
+        clientsMu.RUnlock()
+        if ok {
             recipient.send <- message
             log.Printf("Routing message from %s to %s", msg.SenderID, msg.RecipientID)
         } else {
@@ -79,6 +110,23 @@ func (c *Client) readPump() {
     }
 }
 
+// canRelay reports whether both the sender and the recipient are participants of the message's chat
+func (c *Client) canRelay(msg Message) bool {
+    ctx := context.Background()
+    for _, userID := range []string{msg.SenderID, msg.RecipientID} {
+        participant, err := auth.IsChatParticipant(ctx, c.pool, msg.ChatID, userID)
+        if err != nil {
+            log.Printf("Failed to check chat participant: %v", err)
+            return false
+        }
+        if !participant {
+            log.Printf("User %s is not a participant of chat %s", userID, msg.ChatID)
+            return false
+        }
+    }
+    return true
+}
+
 func (c *Client) writePump() {
     defer c.conn.Close()
     for {
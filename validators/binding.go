@@ -0,0 +1,210 @@
+package validators
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"strings"
+	"tbibi_back_end_go/apierrors"
+	"tbibi_back_end_go/emails"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// FieldError describes why one field of a request body was refused
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+var (
+	phonePattern    = regexp.MustCompile(`^\+?[0-9 ().-]{6,20}$`)
+	usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{3,50}$`)
+)
+
+// oldest birth date accepted, in years before today
+const maxAgeYears = 130
+
+// RegisterBindingValidators adds the custom rules used in `binding` tags and makes
+// validation errors report JSON field names. It must run before the routes serve requests.
+func RegisterBindingValidators() error {
+	v, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return errors.New("unexpected binding validator engine")
+	}
+
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		if name == "" {
+			return field.Name
+		}
+		return name
+	})
+
+	rules := map[string]validator.Func{
+		"notblank": func(fl validator.FieldLevel) bool {
+			return strings.TrimSpace(fl.Field().String()) != ""
+		},
+		"phone": func(fl validator.FieldLevel) bool {
+			value := fl.Field().String()
+			digits := 0
+			for _, r := range value {
+				if r >= '0' && r <= '9' {
+					digits++
+				}
+			}
+			return phonePattern.MatchString(value) && digits >= 6
+		},
+		"sex": func(fl validator.FieldLevel) bool {
+			switch strings.ToLower(fl.Field().String()) {
+			case "male", "female":
+				return true
+			}
+			return false
+		},
+		"birthdate": func(fl validator.FieldLevel) bool {
+			date, err := time.Parse("2006-01-02", fl.Field().String())
+			if err != nil {
+				return false
+			}
+			now := time.Now()
+			return date.Before(now) && date.After(now.AddDate(-maxAgeYears, 0, 0))
+		},
+		"language": func(fl validator.FieldLevel) bool {
+			for _, language := range emails.Languages {
+				if fl.Field().String() == language {
+					return true
+				}
+			}
+			return false
+		},
+		"username": func(fl validator.FieldLevel) bool {
+			return usernamePattern.MatchString(fl.Field().String())
+		},
+		// a single path element, folder names end up on disk under ./uploads
+		"filename": func(fl validator.FieldLevel) bool {
+			value := fl.Field().String()
+			return value != "." && value != ".." && !strings.ContainsAny(value, "/\\\x00")
+		},
+	}
+	for tag, rule := range rules {
+		if err := v.RegisterValidation(tag, rule); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BindJSON binds and validates the JSON body into obj. When the body is refused it
+// answers 400 with the field errors and returns false.
+func BindJSON(c *gin.Context, obj interface{}) bool {
+	return respondBindError(c, c.ShouldBindJSON(obj))
+}
+
+// Bind is BindJSON for handlers that also accept form bodies, picked from the Content-Type
+func Bind(c *gin.Context, obj interface{}) bool {
+	return respondBindError(c, c.ShouldBind(obj))
+}
+
+func respondBindError(c *gin.Context, err error) bool {
+	if err == nil {
+		return true
+	}
+	apierrors.Abort(c, apierrors.CodeValidationFailed, "Invalid request", gin.H{"fields": FieldErrors(err)})
+	return false
+}
+
+// FieldErrors turns a binding error into one FieldError per refused field
+func FieldErrors(err error) []FieldError {
+	var validationErrors validator.ValidationErrors
+	var typeError *json.UnmarshalTypeError
+	var syntaxError *json.SyntaxError
+
+	switch {
+	case errors.As(err, &validationErrors):
+		fields := make([]FieldError, 0, len(validationErrors))
+		for _, fe := range validationErrors {
+			fields = append(fields, FieldError{Field: fieldPath(fe), Rule: fe.Tag(), Message: fieldMessage(fe)})
+		}
+		return fields
+	case errors.As(err, &typeError):
+		return []FieldError{{Field: typeError.Field, Rule: "type", Message: "must be a " + typeError.Type.String()}}
+	case errors.As(err, &syntaxError), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return []FieldError{{Field: "", Rule: "json", Message: "request body must be valid JSON"}}
+	}
+
+	// time.Time and other types that parse themselves
+	var parseError *time.ParseError
+	if errors.As(err, &parseError) {
+		return []FieldError{{Field: "", Rule: "type", Message: "dates must use the RFC 3339 format"}}
+	}
+	return []FieldError{{Field: "", Rule: "invalid", Message: "request body is invalid"}}
+}
+
+// fieldPath drops the struct name from the namespace, "Patient.Email" becomes "Email"
+func fieldPath(fe validator.FieldError) string {
+	namespace := fe.Namespace()
+	if i := strings.Index(namespace, "."); i >= 0 {
+		return namespace[i+1:]
+	}
+	return namespace
+}
+
+func fieldMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required", "notblank":
+		return "is required"
+	case "email":
+		return "must be a valid email address"
+	case "max":
+		if fe.Kind() == reflect.Slice {
+			return fmt.Sprintf("must contain at most %s items", fe.Param())
+		}
+		return fmt.Sprintf("must be at most %s characters long", fe.Param())
+	case "min":
+		if fe.Kind() == reflect.Slice {
+			return fmt.Sprintf("must contain at least %s items", fe.Param())
+		}
+		if fe.Kind() == reflect.String {
+			return fmt.Sprintf("must be at least %s characters long", fe.Param())
+		}
+		return "must be at least " + fe.Param()
+	case "gt":
+		return "must be greater than " + fe.Param()
+	case "oneof":
+		return "must be one of: " + strings.ReplaceAll(fe.Param(), " ", ", ")
+	case "uuid":
+		return "must be a valid ID"
+	case "gtfield":
+		return "must be after " + fe.Param()
+	case "phone":
+		return "must be a valid phone number"
+	case "sex":
+		return "must be male or female"
+	case "birthdate":
+		return "must be a past date formatted as YYYY-MM-DD"
+	case "language":
+		return "must be one of: " + strings.Join(emails.Languages, ", ")
+	case "username":
+		return "must be 3 to 50 letters, digits, dots, dashes or underscores"
+	case "filename":
+		return "must not contain slashes"
+	case "numeric":
+		return "must only contain digits"
+	case "datetime":
+		return "must be formatted as " + strings.NewReplacer("2006", "YYYY", "01", "MM", "02", "DD", "15", "HH", "04", "MM").Replace(fe.Param())
+	case "timezone":
+		return "must be an IANA time zone such as Europe/Berlin"
+	}
+	return "is invalid"
+}
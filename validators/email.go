@@ -0,0 +1,21 @@
+package validators
+
+import (
+	"context"
+	"tbibi_back_end_go/auth"
+	"tbibi_back_end_go/emails"
+	"tbibi_back_end_go/outbox"
+	"time"
+)
+
+// QueueVerificationEmail queues the account activation email in the user's language
+func QueueVerificationEmail(ctx context.Context, db auth.Querier, recipientEmail, language, verificationLink string) error {
+	msg, err := emails.Build(recipientEmail, language, emails.Verification, emails.VerificationData{
+		Link:           verificationLink,
+		ExpiresInHours: int(VerificationTokenTTL / time.Hour),
+	})
+	if err != nil {
+		return err
+	}
+	return outbox.Enqueue(ctx, db, msg)
+}
@@ -0,0 +1,204 @@
+package validators
+
+import (
+	"bufio"
+	_ "embed"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// PasswordPolicy describes what a password must look like. The limits come
+// from the environment, see PasswordPolicyFromEnv.
+type PasswordPolicy struct {
+	MinLength          int
+	MaxLength          int
+	RequireUpper       bool
+	RequireLower       bool
+	RequireDigit       bool
+	RequireSymbol      bool
+	ForbidPersonalInfo bool
+	RejectBreached     bool
+}
+
+// PasswordViolation is one rule a password breaks, returned to the client as is
+type PasswordViolation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// DefaultPasswordPolicy is used for every setting that is not configured.
+// bcrypt only looks at the first 72 bytes, so longer passwords are refused.
+var DefaultPasswordPolicy = PasswordPolicy{
+	MinLength:          10,
+	MaxLength:          72,
+	RequireUpper:       true,
+	RequireLower:       true,
+	RequireDigit:       true,
+	RequireSymbol:      false,
+	ForbidPersonalInfo: true,
+	RejectBreached:     true,
+}
+
+//go:embed breached_passwords.txt
+var embeddedBreachedPasswords string
+
+var (
+	policyOnce        sync.Once
+	policy            PasswordPolicy
+	breachedOnce      sync.Once
+	breachedPasswords map[string]bool
+)
+
+// PasswordPolicyFromEnv reads PASSWORD_MIN_LENGTH, PASSWORD_MAX_LENGTH,
+// PASSWORD_REQUIRE_UPPER, PASSWORD_REQUIRE_LOWER, PASSWORD_REQUIRE_DIGIT,
+// PASSWORD_REQUIRE_SYMBOL, PASSWORD_FORBID_PERSONAL_INFO and PASSWORD_REJECT_BREACHED
+func PasswordPolicyFromEnv() PasswordPolicy {
+	p := DefaultPasswordPolicy
+	p.MinLength = envInt("PASSWORD_MIN_LENGTH", p.MinLength)
+	p.MaxLength = envInt("PASSWORD_MAX_LENGTH", p.MaxLength)
+	if p.MaxLength > 72 {
+		p.MaxLength = 72
+	}
+	p.RequireUpper = envBool("PASSWORD_REQUIRE_UPPER", p.RequireUpper)
+	p.RequireLower = envBool("PASSWORD_REQUIRE_LOWER", p.RequireLower)
+	p.RequireDigit = envBool("PASSWORD_REQUIRE_DIGIT", p.RequireDigit)
+	p.RequireSymbol = envBool("PASSWORD_REQUIRE_SYMBOL", p.RequireSymbol)
+	p.ForbidPersonalInfo = envBool("PASSWORD_FORBID_PERSONAL_INFO", p.ForbidPersonalInfo)
+	p.RejectBreached = envBool("PASSWORD_REJECT_BREACHED", p.RejectBreached)
+	return p
+}
+
+// CheckPassword checks a password against the configured policy. personalInfo
+// holds values the password must not contain, such as the email and username.
+func CheckPassword(password string, personalInfo ...string) []PasswordViolation {
+	policyOnce.Do(func() {
+		policy = PasswordPolicyFromEnv()
+	})
+	return policy.Check(password, personalInfo...)
+}
+
+// Check returns every rule of the policy the password breaks, or nil when it is acceptable
+func (p PasswordPolicy) Check(password string, personalInfo ...string) []PasswordViolation {
+	violations := []PasswordViolation{}
+
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		violations = append(violations, PasswordViolation{"too_short", "Password must be at least " + strconv.Itoa(p.MinLength) + " characters long"})
+	}
+	if len(password) > p.MaxLength {
+		violations = append(violations, PasswordViolation{"too_long", "Password must be at most " + strconv.Itoa(p.MaxLength) + " bytes long"})
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+	if p.RequireUpper && !hasUpper {
+		violations = append(violations, PasswordViolation{"missing_uppercase", "Password must contain an uppercase letter"})
+	}
+	if p.RequireLower && !hasLower {
+		violations = append(violations, PasswordViolation{"missing_lowercase", "Password must contain a lowercase letter"})
+	}
+	if p.RequireDigit && !hasDigit {
+		violations = append(violations, PasswordViolation{"missing_digit", "Password must contain a digit"})
+	}
+	if p.RequireSymbol && !hasSymbol {
+		violations = append(violations, PasswordViolation{"missing_symbol", "Password must contain a symbol"})
+	}
+
+	if p.ForbidPersonalInfo && containsPersonalInfo(password, personalInfo) {
+		violations = append(violations, PasswordViolation{"contains_personal_info", "Password must not contain your email or username"})
+	}
+	if p.RejectBreached && isBreached(password) {
+		violations = append(violations, PasswordViolation{"breached", "This password appears in known data breaches, please choose another one"})
+	}
+
+	if len(violations) == 0 {
+		return nil
+	}
+	return violations
+}
+
+// containsPersonalInfo reports whether the password contains one of the values,
+// or the local part of an email, ignoring case. Values shorter than 3 characters are ignored.
+func containsPersonalInfo(password string, personalInfo []string) bool {
+	lower := strings.ToLower(password)
+	for _, value := range personalInfo {
+		value = strings.ToLower(strings.TrimSpace(value))
+		candidates := []string{value}
+		if at := strings.Index(value, "@"); at > 0 {
+			candidates = append(candidates, value[:at])
+		}
+		for _, candidate := range candidates {
+			if len(candidate) >= 3 && strings.Contains(lower, candidate) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isBreached reports whether the password is on the breached password list
+func isBreached(password string) bool {
+	breachedOnce.Do(func() {
+		breachedPasswords = loadBreachedPasswords()
+	})
+	return breachedPasswords[strings.ToLower(password)]
+}
+
+// loadBreachedPasswords reads the embedded list and, when BREACHED_PASSWORDS_FILE is set, that file too
+func loadBreachedPasswords() map[string]bool {
+	passwords := map[string]bool{}
+	addPasswordLines(passwords, bufio.NewScanner(strings.NewReader(embeddedBreachedPasswords)))
+
+	if path := os.Getenv("BREACHED_PASSWORDS_FILE"); path != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			log.Printf("Could not open breached password list %s: %v", path, err)
+			return passwords
+		}
+		defer file.Close()
+		addPasswordLines(passwords, bufio.NewScanner(file))
+	}
+	return passwords
+}
+
+func addPasswordLines(passwords map[string]bool, scanner *bufio.Scanner) {
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		passwords[strings.ToLower(line)] = true
+	}
+}
+
+func envInt(name string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+func envBool(name string, fallback bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(name))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
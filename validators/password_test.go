@@ -0,0 +1,61 @@
+package validators
+
+import (
+	"strings"
+	"testing"
+)
+
+func violationCodes(violations []PasswordViolation) []string {
+	codes := []string{}
+	for _, violation := range violations {
+		codes = append(codes, violation.Code)
+	}
+	return codes
+}
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	strict := DefaultPasswordPolicy
+	strict.RequireSymbol = true
+
+	tests := []struct {
+		name     string
+		policy   PasswordPolicy
+		password string
+		personal []string
+		want     []string
+	}{
+		{"acceptable", DefaultPasswordPolicy, "Blue7Lantern", nil, nil},
+		{"minimum length", DefaultPasswordPolicy, "Blue7Lante", nil, nil},
+		{"one character short", DefaultPasswordPolicy, "Blue7Lant", nil, []string{"too_short"}},
+		{"length counts characters, not bytes", DefaultPasswordPolicy, "Bé7éééééé", nil, []string{"too_short"}},
+		{"maximum length", DefaultPasswordPolicy, "Aa1" + strings.Repeat("x", 69), nil, nil},
+		{"one byte too long", DefaultPasswordPolicy, "Aa1" + strings.Repeat("x", 70), nil, []string{"too_long"}},
+		{"no uppercase", DefaultPasswordPolicy, "blue7lantern", nil, []string{"missing_uppercase"}},
+		{"no lowercase", DefaultPasswordPolicy, "BLUE7LANTERN", nil, []string{"missing_lowercase"}},
+		{"no digit", DefaultPasswordPolicy, "BlueLantern", nil, []string{"missing_digit"}},
+		{"symbol not required", DefaultPasswordPolicy, "Blue7Lantern", nil, nil},
+		{"symbol required", strict, "Blue7Lantern", nil, []string{"missing_symbol"}},
+		{"symbol given", strict, "Blue7Lantern!", nil, nil},
+		{"every class missing", strict, "          ", nil, []string{"missing_uppercase", "missing_lowercase", "missing_digit"}},
+		{"contains the email", DefaultPasswordPolicy, "Samira1990Pass", []string{"samira@example.com"}, []string{"contains_personal_info"}},
+		{"short personal values are ignored", DefaultPasswordPolicy, "Blue7Lantern", []string{"bl"}, nil},
+		{"breached", DefaultPasswordPolicy, "Password123", nil, []string{"breached"}},
+		{"breached ignoring case", DefaultPasswordPolicy, "pASSWORD123", nil, []string{"breached"}},
+	}
+
+	for _, test := range tests {
+		got := violationCodes(test.policy.Check(test.password, test.personal...))
+		if strings.Join(got, ",") != strings.Join(test.want, ",") {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestPasswordPolicyRulesOff(t *testing.T) {
+	relaxed := PasswordPolicy{MinLength: 1, MaxLength: 72}
+	for _, password := range []string{"password123", "samira", "a"} {
+		if violations := relaxed.Check(password, "samira@example.com"); violations != nil {
+			t.Errorf("%q: got %v, want no violations", password, violationCodes(violations))
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package validators
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"tbibi_back_end_go/auth"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Type of the rows of verification_tokens, password reset tokens have their own table
+const TokenTypeAccountValidation = "Account Validation"
+
+// VerificationTokenTTL is how long an account activation link stays valid
+const VerificationTokenTTL = 24 * time.Hour
+
+// VerificationResendInterval is the minimum time between two activation emails to the same address
+const VerificationResendInterval = time.Minute
+
+// GenerateVerificationLink creates a new activation token for the email, replacing
+// any earlier one, and returns the link to put in the verification email. Pass
+// the registration transaction as db so the token is only kept if the account
+// is. It returns an empty string when the token could not be created.
+func GenerateVerificationLink(email string, db auth.Querier) string {
+	token, err := generateToken()
+	if err != nil {
+		log.Println("Error generating verification token:", err)
+		return ""
+	}
+
+	err = db.QueryRow(context.Background(), `
+		WITH replaced AS (
+			DELETE FROM verification_tokens WHERE (LOWER(email) = LOWER($2) AND type = $3) OR expires_at < NOW()
+		)
+		INSERT INTO verification_tokens (token, email, type, created_at, expires_at)
+		VALUES ($1, $2, $3, NOW(), $4)
+		RETURNING token`,
+		token, email, TokenTypeAccountValidation, time.Now().Add(VerificationTokenTTL)).Scan(&token)
+	if err != nil {
+		log.Println("Error storing verification token:", err)
+		return ""
+	}
+
+	return apiBaseURL + "/activate_account?token=" + token
+}
+
+// VerificationRecentlySent reports whether an activation email was sent to the address less than VerificationResendInterval ago
+func VerificationRecentlySent(ctx context.Context, pool *pgxpool.Pool, email string) (bool, error) {
+	var recent bool
+	err := pool.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM verification_tokens
+		WHERE LOWER(email) = LOWER($1) AND type = $2 AND created_at > $3)`,
+		email, TokenTypeAccountValidation, time.Now().Add(-VerificationResendInterval)).Scan(&recent)
+	return recent, err
+}
+
+// apiBaseURL is the public address of the API that activation links point to, see LoadAPIBaseURL
+var apiBaseURL string
+
+// LoadAPIBaseURL reads the public address of the API from API_BASE_URL. It is
+// required, links are never built from the Host of a request since clients
+// control it.
+func LoadAPIBaseURL() error {
+	base := strings.TrimRight(os.Getenv("API_BASE_URL"), "/")
+	if base == "" {
+		return errors.New("API_BASE_URL is not set")
+	}
+	parsed, err := url.Parse(base)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return errors.New("API_BASE_URL must be an absolute http or https URL")
+	}
+	apiBaseURL = base
+	return nil
+}
+
+// FrontendURL returns the address of a page of the web app, based on FRONTEND_BASE_URL
+func FrontendURL(path string) string {
+	base := os.Getenv("FRONTEND_BASE_URL")
+	if base == "" {
+		base = "http://localhost:3000"
+	}
+	return strings.TrimRight(base, "/") + path
+}
+
+func generateToken() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}